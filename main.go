@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io/fs"
@@ -67,11 +69,21 @@ func copyTemplate(projectName string) error {
 }
 
 func createEnvFile(projectName string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
 	envContent := `
 # Application environment
 APP_ENV=development
 PORT=80
 DEBUG=true
+SECRET_KEY=` + hex.EncodeToString(secret) + `
+APP_URL=http://localhost:80
+# Seconds /readyz fails before the server stops accepting connections,
+# above the period of the readiness probe
+SHUTDOWN_DELAY_SECONDS=15
 
 # Database configuration
 DB_HOST=localhost
@@ -79,15 +91,41 @@ DB_USER=salut
 DB_NAME=dbname
 DB_PASSWORD=salutpassword
 DB_PORT=5432
+# Apply the tenant isolation policies to the table owner too
+DB_ROW_LEVEL_SECURITY=false
 
 # Google OAuth configuration
 GOOGLE_CLIENT_ID=pattern.apps.googleusercontent.com
 GOOGLE_CLIENT_SECRET=GX-pattern
 GOOGLE_REDIRECT_URL=http://localhost:80/auth/google/callback
 
+# Email (Resend)
+RESEND_API=
+MAIL_FROM=
+
 # Admin configuration
 ADMIN=admin@admin
 ADMIN_PASSWORD=supersecurepassword101MM
+
+# Password hashing (argon2id)
+ARGON2_MEMORY=65536
+ARGON2_ITERATIONS=3
+ARGON2_PARALLELISM=2
+
+# Password policy
+PASSWORD_MIN_LENGTH=8
+PASSWORD_MIN_SCORE=2
+PASSWORD_BANNED=
+PASSWORD_BREACHED_FILE=
+
+# Prometheus metrics, on a separate listener (e.g. 127.0.0.1:9090)
+# or on /metrics with METRICS_TOKEN as bearer token
+METRICS_ADDR=
+METRICS_TOKEN=
+
+# Tracing: otlp (see OTEL_EXPORTER_OTLP_ENDPOINT), stdout or file
+TRACING_EXPORTER=
+TRACING_FILE=traces.json
 `
 	envPath := filepath.Join(projectName, ".env")
 	return os.WriteFile(envPath, []byte(envContent), 0644)
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net"
+	"time"
+	"{{projectName}}/db"
+)
+
+// Actions recorded in audit_events. Names are "<subject>.<verb>" so the
+// admin page can filter on a prefix.
+const (
+	LoginSuccess         = "login.success"
+	LoginFailure         = "login.failure"
+	OTPIssued            = "otp.issued"
+	OTPVerified          = "otp.verified"
+	OTPFailed            = "otp.failed"
+	SessionCreated       = "session.created"
+	SessionRevoked       = "session.revoked"
+	AdminRoleChanged     = "admin.user_role_changed"
+	AdminUserSuspended   = "admin.user_suspended"
+	AdminUserReactivated = "admin.user_reactivated"
+	AdminPasswordReset   = "admin.user_password_reset"
+	AdminSessionsRevoked = "admin.user_sessions_revoked"
+	AdminUserDeleted     = "admin.user_deleted"
+	AdminUserRestored    = "admin.user_restored"
+	AdminUserErased      = "admin.user_erased"
+	AdminRoleCreated     = "admin.role_created"
+	AdminRoleUpdated     = "admin.role_updated"
+	AdminRoleDeleted     = "admin.role_deleted"
+	AdminLockoutCleared  = "admin.lockout_cleared"
+	ImpersonationStarted = "impersonation.started"
+	ImpersonationStopped = "impersonation.stopped"
+	PasswordChanged      = "account.password_changed"
+	EmailChangeRequested = "account.email_change_requested"
+	EmailChanged         = "account.email_changed"
+	DataExported         = "account.data_exported"
+	ErasureScheduled     = "account.erasure_scheduled"
+	ErasureCancelled     = "account.erasure_cancelled"
+	AccountErased        = "account.erased"
+	APITokenCreated      = "account.api_token_created"
+	APITokenRevoked      = "account.api_token_revoked"
+	OrgCreated           = "org.created"
+	OrgMemberInvited     = "org.member_invited"
+	OrgInvitationRevoked = "org.invitation_revoked"
+	OrgMemberJoined      = "org.member_joined"
+	OrgMemberRoleChanged = "org.member_role_changed"
+	OrgMemberRemoved     = "org.member_removed"
+	OrgMemberLeft        = "org.member_left"
+)
+
+// Event is a security-relevant action. ActorID defaults to the user stored
+// in the context by WithActor; IP and user agent always come from WithClient.
+type Event struct {
+	Action   string
+	ActorID  string
+	Target   string
+	Metadata map[string]string
+}
+
+type ctxKey int
+
+const (
+	clientKey ctxKey = iota
+	actorKey
+	impersonatorKey
+)
+
+type client struct {
+	ip        net.IP
+	userAgent string
+}
+
+// WithClient attaches the caller's IP address and user agent to ctx.
+func WithClient(ctx context.Context, ip net.IP, userAgent string) context.Context {
+	return context.WithValue(ctx, clientKey, client{ip: ip, userAgent: userAgent})
+}
+
+// WithActor attaches the authenticated user id to ctx.
+func WithActor(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, actorKey, userID)
+}
+
+// WithImpersonator marks ctx as served by an impersonation session opened
+// by the admin adminID. Events recorded with it carry an "impersonator_id"
+// metadata entry.
+func WithImpersonator(ctx context.Context, adminID string) context.Context {
+	return context.WithValue(ctx, impersonatorKey, adminID)
+}
+
+// Recorder persists audit events. Recording never fails the caller: errors
+// are logged and the action goes on.
+type Recorder struct {
+	store  db.AuditStore
+	logger *slog.Logger
+}
+
+func NewRecorder(store db.AuditStore, logger *slog.Logger) *Recorder {
+	return &Recorder{store: store, logger: logger}
+}
+
+// Record is a no-op on a nil Recorder.
+func (r *Recorder) Record(ctx context.Context, e Event) {
+	if r == nil {
+		return
+	}
+
+	event := &db.AuditEvent{
+		Action:   e.Action,
+		ActorID:  e.ActorID,
+		Target:   e.Target,
+		Metadata: e.Metadata,
+	}
+	if event.ActorID == "" {
+		event.ActorID, _ = ctx.Value(actorKey).(string)
+	}
+	if adminID, ok := ctx.Value(impersonatorKey).(string); ok {
+		event.Metadata = maps.Clone(e.Metadata)
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string, 1)
+		}
+		event.Metadata["impersonator_id"] = adminID
+	}
+	if c, ok := ctx.Value(clientKey).(client); ok {
+		if c.ip != nil {
+			event.IPAddress = c.ip.String()
+		}
+		event.UserAgent = c.userAgent
+	}
+
+	// The request may already be cancelled, the event must still be written.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := r.store.CreateAuditEvent(ctx, event); err != nil {
+		r.logger.ErrorContext(ctx, "unable to record audit event",
+			slog.String("action", e.Action),
+			slog.String("error", err.Error()))
+	}
+}
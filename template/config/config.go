@@ -2,9 +2,12 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
@@ -12,13 +15,23 @@ import (
 )
 
 type Config struct {
-	Env      string
-	Port     string
-	Debug    bool
-	Database *Database
-	Google   *GoogleOAuth
-	Admin    *AdminConfig
-	MAIlAPI  string
+	Env       string
+	Port      string
+	Debug     bool
+	Database  *Database
+	Google    *GoogleOAuth
+	Admin     *AdminConfig
+	Password  *PasswordConfig
+	Metrics   *MetricsConfig
+	Tracing   *TracingConfig
+	MAIlAPI   string
+	MailFrom  string
+	AppURL    string
+	SecretKey string
+	// ShutdownDelay is how long readiness fails before the server stops
+	// accepting connections, to be set above the readiness probe period;
+	// 15 seconds by default, above the usual 10.
+	ShutdownDelay time.Duration
 }
 
 type Database struct {
@@ -27,6 +40,9 @@ type Database struct {
 	Database string
 	Password string
 	Port     string
+	// RowLevelSecurity enforces the tenant isolation policies even though
+	// the application connects as the owner of the tables.
+	RowLevelSecurity bool
 }
 
 type GoogleOAuth struct {
@@ -40,6 +56,61 @@ type AdminConfig struct {
 	ADMIN_PASSWORD string
 }
 
+// PasswordConfig holds the argon2id cost parameters used to hash passwords
+// and the policy applied to new passwords.
+type PasswordConfig struct {
+	Memory      int // KiB
+	Iterations  int
+	Parallelism int
+	SaltLength  int
+	KeyLength   int
+
+	MinLength    int
+	MinScore     int      // zxcvbn scale, 0-4
+	Banned       []string // extra words a password must not contain
+	BreachedFile string   // optional HIBP-style SHA-1 list
+}
+
+// Validate checks the argon2id parameters against the ranges of RFC 9106
+// and of their types, out of which they would wrap around or make every
+// hash panic.
+func (p *PasswordConfig) Validate() error {
+	limits := []struct {
+		name          string
+		value, lo, hi int64
+	}{
+		{"ARGON2_PARALLELISM", int64(p.Parallelism), 1, math.MaxUint8},
+		{"ARGON2_MEMORY", int64(p.Memory), 8 * int64(max(p.Parallelism, 1)), math.MaxUint32},
+		{"ARGON2_ITERATIONS", int64(p.Iterations), 1, math.MaxUint32},
+		{"ARGON2_SALT_LENGTH", int64(p.SaltLength), 8, math.MaxUint32},
+		{"ARGON2_KEY_LENGTH", int64(p.KeyLength), 4, math.MaxUint32},
+	}
+	for _, l := range limits {
+		if l.value < l.lo || l.value > l.hi {
+			return fmt.Errorf("%s must be between %d and %d, got %d", l.name, l.lo, l.hi, l.value)
+		}
+	}
+	return nil
+}
+
+// MetricsConfig tells where the Prometheus metrics are served. Addr serves
+// them on a separate listener, e.g. "127.0.0.1:9090", kept off the public
+// port; otherwise they are served on /metrics of the application when Token
+// is set, to be sent as a bearer token. Without either they are not served.
+type MetricsConfig struct {
+	Addr  string
+	Token string
+}
+
+// TracingConfig selects where the traces go: "otlp", set up by the standard
+// OTEL_EXPORTER_OTLP_* variables, "stdout", or "file" to append them to
+// File. Traces are not exported when Exporter is empty.
+type TracingConfig struct {
+	Exporter    string
+	File        string
+	ServiceName string
+}
+
 func (g *GoogleOAuth) Oauth() *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     g.ClientID,
@@ -60,16 +131,24 @@ func Load() *Config {
 		_ = godotenv.Load()
 
 		cfg = &Config{
-			Env:     getEnv("APP_ENV", "development"),
-			Port:    getEnv("PORT", "8080"),
-			Debug:   getEnvAsBool("DEBUG", true),
-			MAIlAPI: getEnv("RESEND_API", ""),
+			Env:       getEnv("APP_ENV", "development"),
+			Port:      getEnv("PORT", "8080"),
+			Debug:     getEnvAsBool("DEBUG", true),
+			MAIlAPI:   getEnv("RESEND_API", ""),
+			MailFrom:  getEnv("MAIL_FROM", ""),
+			AppURL:    getEnv("APP_URL", "http://localhost:8080"),
+			SecretKey: getEnv("SECRET_KEY", ""),
+
+			ShutdownDelay: time.Duration(getEnvAsInt("SHUTDOWN_DELAY_SECONDS", 15)) * time.Second,
+
 			Database: &Database{
 				Host:     getEnv("DB_HOST", "localhost"),
 				User:     getEnv("DB_USER", "user"),
 				Database: getEnv("DB_NAME", "dbname"),
 				Password: getEnv("DB_PASSWORD", "dbpassword"),
 				Port:     getEnv("DB_PORT", "5432"),
+
+				RowLevelSecurity: getEnvAsBool("DB_ROW_LEVEL_SECURITY", false),
 			},
 			Google: &GoogleOAuth{
 				ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
@@ -80,6 +159,27 @@ func Load() *Config {
 				ADMIN:          getEnv("Admin", "djfdsjkjk"),
 				ADMIN_PASSWORD: getEnv("djjdj", "djqkdj"),
 			},
+			Password: &PasswordConfig{
+				Memory:      getEnvAsAnyInt("ARGON2_MEMORY", 64*1024),
+				Iterations:  getEnvAsAnyInt("ARGON2_ITERATIONS", 3),
+				Parallelism: getEnvAsAnyInt("ARGON2_PARALLELISM", 2),
+				SaltLength:  getEnvAsAnyInt("ARGON2_SALT_LENGTH", 16),
+				KeyLength:   getEnvAsAnyInt("ARGON2_KEY_LENGTH", 32),
+
+				MinLength:    getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+				MinScore:     getEnvAsInt("PASSWORD_MIN_SCORE", 2),
+				Banned:       getEnvAsList("PASSWORD_BANNED"),
+				BreachedFile: getEnv("PASSWORD_BREACHED_FILE", ""),
+			},
+			Metrics: &MetricsConfig{
+				Addr:  getEnv("METRICS_ADDR", ""),
+				Token: getEnv("METRICS_TOKEN", ""),
+			},
+			Tracing: &TracingConfig{
+				Exporter:    getEnv("TRACING_EXPORTER", ""),
+				File:        getEnv("TRACING_FILE", "traces.json"),
+				ServiceName: getEnv("OTEL_SERVICE_NAME", "{{projectName}}"),
+			},
 		}
 	})
 	return cfg
@@ -100,6 +200,33 @@ func getEnvAsBool(name string, defaultVal bool) bool {
 	return defaultVal
 }
 
+func getEnvAsInt(name string, defaultVal int) int {
+	valStr := os.Getenv(name)
+	if val, err := strconv.Atoi(valStr); err == nil && val > 0 {
+		return val
+	}
+	return defaultVal
+}
+
+// getEnvAsAnyInt keeps the values below 1 that getEnvAsInt replaces with
+// the default, for settings that are validated and rejected instead.
+func getEnvAsAnyInt(name string, defaultVal int) int {
+	if val, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
+func getEnvAsList(name string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func (d *Database) String() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", d.User, d.Password, d.Host, d.Port, d.Database)
 }
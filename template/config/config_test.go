@@ -0,0 +1,45 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordConfigValidate(t *testing.T) {
+	valid := func() PasswordConfig {
+		return PasswordConfig{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	}
+	tests := []struct {
+		name   string
+		change func(p *PasswordConfig)
+		want   string // setting named in the error, "" when valid
+	}{
+		{"defaults", func(p *PasswordConfig) {}, ""},
+		{"parallelism 255", func(p *PasswordConfig) { p.Parallelism, p.Memory = 255, 8*255 }, ""},
+		{"parallelism wraps", func(p *PasswordConfig) { p.Parallelism = 256 }, "ARGON2_PARALLELISM"},
+		{"parallelism zero", func(p *PasswordConfig) { p.Parallelism = 0 }, "ARGON2_PARALLELISM"},
+		{"parallelism negative", func(p *PasswordConfig) { p.Parallelism = -1 }, "ARGON2_PARALLELISM"},
+		{"memory below 8 per lane", func(p *PasswordConfig) { p.Memory = 15 }, "ARGON2_MEMORY"},
+		{"memory wraps", func(p *PasswordConfig) { p.Memory = 1 << 32 }, "ARGON2_MEMORY"},
+		{"iterations zero", func(p *PasswordConfig) { p.Iterations = 0 }, "ARGON2_ITERATIONS"},
+		{"iterations wrap", func(p *PasswordConfig) { p.Iterations = 1 << 32 }, "ARGON2_ITERATIONS"},
+		{"salt too short", func(p *PasswordConfig) { p.SaltLength = 4 }, "ARGON2_SALT_LENGTH"},
+		{"key too short", func(p *PasswordConfig) { p.KeyLength = 0 }, "ARGON2_KEY_LENGTH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.change(&p)
+			err := p.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
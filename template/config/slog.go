@@ -3,8 +3,11 @@ package config
 import (
 	"log/slog"
 	"os"
+	"{{projectName}}/logctx"
 )
 
+// NewSlog logs JSON in production and text otherwise. Records logged with a
+// request's context carry its request ID, user ID and route.
 func NewSlog(env string) *slog.Logger {
 	var handler slog.Handler
 	var level slog.Level
@@ -15,5 +18,5 @@ func NewSlog(env string) *slog.Logger {
 		level = slog.LevelDebug
 		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
 	}
-	return slog.New(handler)
+	return slog.New(logctx.NewHandler(handler))
 }
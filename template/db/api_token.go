@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const apiTokenAttributes = "id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at"
+
+// APIToken is a personal access token. Only the hash of the token is
+// stored; ExpiresAt is nil for a token that never expires.
+type APIToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t *APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	GetUserAPITokens(ctx context.Context, userID string) ([]*APIToken, error)
+	TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error
+	DeleteAPIToken(ctx context.Context, userID, id string) error
+	DeleteExpiredAPITokens(ctx context.Context) (int64, error)
+}
+
+func (r *PostgresStore) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	query := `
+        INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	return r.DB.QueryRowContext(ctx, query,
+		t.UserID, t.Name, t.TokenHash, pq.Array(t.Scopes), t.CreatedAt, t.ExpiresAt,
+	).Scan(&t.ID)
+}
+
+// GetAPITokenByHash returns an unexpired token, or sql.ErrNoRows.
+func (r *PostgresStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	query := fmt.Sprintf(`
+        SELECT %s FROM api_tokens
+        WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())`, apiTokenAttributes)
+	return scanAPIToken(r.DB.QueryRowContext(ctx, query, tokenHash))
+}
+
+// GetUserAPITokens lists the tokens of userID, expired ones included, most
+// recent first.
+func (r *PostgresStore) GetUserAPITokens(ctx context.Context, userID string) ([]*APIToken, error) {
+	query := fmt.Sprintf(`SELECT %s FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`, apiTokenAttributes)
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	t := &APIToken{}
+	if err := row.Scan(
+		&t.ID, &t.UserID, &t.Name, &t.TokenHash, pq.Array(&t.Scopes), &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *PostgresStore) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = $1 WHERE id = $2`, usedAt, id)
+	return err
+}
+
+// DeleteAPIToken revokes a token of userID. It returns sql.ErrNoRows when
+// userID has no such token.
+func (r *PostgresStore) DeleteAPIToken(ctx context.Context, userID, id string) error {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+func (r *PostgresStore) DeleteExpiredAPITokens(ctx context.Context) (int64, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
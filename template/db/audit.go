@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const auditEventAttributes = "e.id, e.action, e.actor_id, COALESCE(u.email, ''), e.target, e.ip_address, e.user_agent, e.metadata, e.created_at"
+
+type AuditEvent struct {
+	ID         int64
+	Action     string
+	ActorID    string
+	ActorEmail string
+	Target     string
+	IPAddress  string
+	UserAgent  string
+	Metadata   map[string]string
+	CreatedAt  time.Time
+}
+
+// AuditFilter narrows ListAuditEvents; zero values match everything.
+type AuditFilter struct {
+	Action string // prefix, "login" matches "login.success" and "login.failure"
+	Actor  string // substring of the actor's email
+	Target string
+	UserID string // events caused by the user or targeting their id or email
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+type AuditStore interface {
+	CreateAuditEvent(ctx context.Context, e *AuditEvent) error
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]*AuditEvent, error)
+}
+
+func (r *PostgresStore) CreateAuditEvent(ctx context.Context, e *AuditEvent) error {
+	metadata, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return err
+	}
+	if e.Metadata == nil {
+		metadata = []byte("{}")
+	}
+
+	query := `
+        INSERT INTO audit_events (action, actor_id, target, ip_address, user_agent, metadata)
+        VALUES ($1, NULLIF($2, '')::UUID, $3, NULLIF($4, '')::INET, $5, $6)
+        RETURNING id, created_at`
+	return r.DB.QueryRowContext(ctx, query,
+		e.Action, e.ActorID, e.Target, e.IPAddress, e.UserAgent, metadata,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+func (r *PostgresStore) ListAuditEvents(ctx context.Context, f AuditFilter) ([]*AuditEvent, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Action != "" {
+		where = append(where, "e.action LIKE "+arg(escapeLike(f.Action)+"%"))
+	}
+	if f.Actor != "" {
+		where = append(where, "u.email ILIKE "+arg("%"+escapeLike(f.Actor)+"%"))
+	}
+	if f.Target != "" {
+		where = append(where, "e.target = "+arg(f.Target))
+	}
+	if f.UserID != "" {
+		id := arg(f.UserID)
+		where = append(where, fmt.Sprintf(
+			"(e.actor_id = %[1]s::UUID OR e.target = %[1]s OR e.target = (SELECT email FROM users WHERE id = %[1]s::UUID))", id))
+	}
+	if f.From != nil {
+		where = append(where, "e.created_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "e.created_at < "+arg(*f.To))
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM audit_events e LEFT JOIN users u ON u.id = e.actor_id`, auditEventAttributes)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY e.created_at DESC, e.id DESC LIMIT " + arg(f.Limit) + " OFFSET " + arg(f.Offset)
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		e := &AuditEvent{}
+		var actorID, ip sql.NullString
+		var metadata []byte
+		if err := rows.Scan(
+			&e.ID, &e.Action, &actorID, &e.ActorEmail, &e.Target, &ip, &e.UserAgent, &metadata, &e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		e.ActorID = actorID.String
+		e.IPAddress = ip.String
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -3,22 +3,39 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"embed"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"time"
 	"{{projectName}}/utils"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed migration/*.sql
 var migrationFS embed.FS
 
+// NewDB opens the database through a driver tracing each query as a child
+// of the span in its context. Queries outside a trace, such as migrations,
+// are not traced.
 func NewDB(databaseURL string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+	db, err := otelsql.Open("postgres", databaseURL,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -47,6 +64,29 @@ func MigrateSchema(db *sql.DB, logger *slog.Logger) error {
 	return nil
 }
 
+// SchemaVersion returns the version of the last migration applied to the
+// database and of the last one embedded in the binary, which differ when
+// the migrations did not all run.
+func SchemaVersion(ctx context.Context, db *sql.DB) (current, latest int64, err error) {
+	files, err := fs.Glob(migrationFS, "migration/*.sql")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, file := range files {
+		version, err := goose.NumericComponent(file)
+		if err != nil {
+			return 0, 0, err
+		}
+		latest = max(latest, version)
+	}
+
+	current, err = goose.GetDBVersionContext(ctx, db)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	return current, latest, nil
+}
+
 func CreateSeed(db *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -64,8 +104,8 @@ func CreateSeed(db *sql.DB) error {
 
 	query := `
         INSERT INTO users (email, password_hash, role)
-        VALUES ($1, $2, 'admin'::role)
-        ON CONFLICT (email) DO NOTHING;`
+        VALUES ($1, $2, 'admin')
+        ON CONFLICT (email) WHERE status <> 'deleted' DO NOTHING;`
 	if _, err := db.ExecContext(ctx, query, adminEmail, hashedPassword); err != nil {
 		return fmt.Errorf("failed to seed admin user: %w", err)
 	}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// EmailChange is a pending address change, applied once the link sent to
+// NewEmail is followed.
+type EmailChange struct {
+	ID        string
+	UserID    string
+	NewEmail  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type EmailChangeStore interface {
+	CreateEmailChange(ctx context.Context, c *EmailChange) error
+	ConsumeEmailChange(ctx context.Context, tokenHash string) (*EmailChange, error)
+	DeleteExpiredEmailChanges(ctx context.Context) (int64, error)
+}
+
+// CreateEmailChange stores a new request and drops the user's earlier ones.
+func (r *PostgresStore) CreateEmailChange(ctx context.Context, c *EmailChange) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, c.UserID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO email_changes (user_id, new_email, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, c.UserID, c.NewEmail, c.TokenHash, c.CreatedAt, c.ExpiresAt).Scan(&c.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConsumeEmailChange deletes an unexpired request and returns it. It returns
+// sql.ErrNoRows when no such request exists.
+func (r *PostgresStore) ConsumeEmailChange(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	c := &EmailChange{}
+	query := `
+        DELETE FROM email_changes WHERE token_hash = $1 AND expires_at > NOW()
+        RETURNING id, user_id, new_email, token_hash, created_at, expires_at`
+	if err := r.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&c.ID, &c.UserID, &c.NewEmail, &c.TokenHash, &c.CreatedAt, &c.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *PostgresStore) DeleteExpiredEmailChanges(ctx context.Context) (int64, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM email_changes WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
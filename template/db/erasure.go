@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SetErasureScheduledAt schedules the erasure of the user at at, or cancels
+// it when at is nil. Scheduling fails with ErrLastOwner while the user is
+// the only owner of an organization that has other members.
+func (r *PostgresStore) SetErasureScheduledAt(ctx context.Context, id string, at *time.Time) error {
+	return r.acrossTenants(ctx, func(q querier) error {
+		if at != nil {
+			if _, err := checkOwnerships(ctx, q, id); err != nil {
+				return err
+			}
+		}
+		_, err := q.ExecContext(ctx, `UPDATE users SET erasure_scheduled_at = $1, updated_at = NOW() WHERE id = $2`, at, id)
+		return err
+	})
+}
+
+// GetUsersDueForErasure includes soft-deleted accounts.
+func (r *PostgresStore) GetUsersDueForErasure(ctx context.Context, before time.Time) ([]*User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE erasure_scheduled_at <= $1`, userAttribute)
+	rows, err := r.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		u.PasswordHash = ""
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// EraseUser deletes u and everything cascading from it, along with the
+// invitations sent to one of emails and the organizations u is the only
+// member of. Audit events are kept but every reference to the user, by id
+// or by one of emails, is replaced with pseudonym, and the network details
+// of the events they caused are dropped. It fails with ErrLastOwner while
+// u is the only owner of an organization that has other members.
+func (r *PostgresStore) EraseUser(ctx context.Context, u *User, emails []string, pseudonym string) error {
+	return r.acrossTenants(ctx, func(q querier) error {
+		alone, err := checkOwnerships(ctx, q, u.ID)
+		if err != nil {
+			return err
+		}
+
+		references := pq.Array(append([]string{u.ID}, emails...))
+		statements := []struct {
+			query string
+			args  []any
+		}{
+			{`UPDATE audit_events SET actor_id = NULL, ip_address = NULL, user_agent = '',
+                  metadata = metadata || jsonb_build_object('actor', $2::TEXT)
+              WHERE actor_id = $1`, []any{u.ID, pseudonym}},
+			{`UPDATE audit_events SET target = $2 WHERE target = ANY($1)`, []any{references, pseudonym}},
+			{`UPDATE audit_events SET metadata = (
+                  SELECT jsonb_object_agg(key, CASE WHEN value = ANY($1) THEN $2 ELSE value END)
+                  FROM jsonb_each_text(metadata))
+              WHERE EXISTS (SELECT 1 FROM jsonb_each_text(metadata) WHERE value = ANY($1))`, []any{references, pseudonym}},
+			{`DELETE FROM login_attempts WHERE subject = ANY($1)`, []any{references}},
+			{`DELETE FROM invitations WHERE email = ANY($1)`, []any{pq.Array(emails)}},
+			{`DELETE FROM organizations WHERE id = ANY($1)`, []any{pq.Array(alone)}},
+			{`DELETE FROM users WHERE id = $1`, []any{u.ID}},
+		}
+		for _, s := range statements {
+			if _, err := q.ExecContext(ctx, s.query, s.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
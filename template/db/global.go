@@ -4,4 +4,12 @@ type Store interface {
 	SessionStore
 	UserStore
 	OtpStore
+	LockoutStore
+	MagicLinkStore
+	EmailChangeStore
+	OrganizationStore
+	InvitationStore
+	APITokenStore
+	RoleStore
+	AuditStore
 }
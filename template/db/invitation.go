@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const invitationAttributes = "i.id, i.org_id, o.name, i.email, i.role, i.token_hash, COALESCE(i.invited_by::TEXT, ''), i.created_at, i.expires_at"
+
+// Invitation asks Email to join an organization with Role. It is deleted
+// once accepted.
+type Invitation struct {
+	ID        string
+	OrgID     string
+	OrgName   string
+	Email     string
+	Role      string
+	TokenHash string
+	InvitedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type InvitationStore interface {
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error)
+	AcceptInvitation(ctx context.Context, id, userID string) error
+	DeleteExpiredInvitations(ctx context.Context) (int64, error)
+
+	// Scoped to the tenant of ctx, see WithTenant.
+	CreateInvitation(ctx context.Context, i *Invitation) error
+	GetPendingInvitations(ctx context.Context) ([]*Invitation, error)
+	DeleteInvitation(ctx context.Context, id string) error
+}
+
+// CreateInvitation stores i in the tenant's organization and replaces any
+// pending invitation of the same email.
+func (r *PostgresStore) CreateInvitation(ctx context.Context, i *Invitation) error {
+	return r.inTenant(ctx, func(q querier, tenantID string) error {
+		i.OrgID = tenantID
+		if _, err := q.ExecContext(ctx, `DELETE FROM invitations WHERE org_id = $1 AND email = $2`, i.OrgID, i.Email); err != nil {
+			return err
+		}
+
+		query := `
+            INSERT INTO invitations (org_id, email, role, token_hash, invited_by, created_at, expires_at)
+            VALUES ($1, $2, $3, $4, NULLIF($5, '')::UUID, $6, $7) RETURNING id`
+		return q.QueryRowContext(ctx, query,
+			i.OrgID, i.Email, i.Role, i.TokenHash, i.InvitedBy, i.CreatedAt, i.ExpiresAt,
+		).Scan(&i.ID)
+	})
+}
+
+func (r *PostgresStore) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error) {
+	query := fmt.Sprintf(`
+        SELECT %s FROM invitations i JOIN organizations o ON o.id = i.org_id
+        WHERE i.token_hash = $1 AND i.expires_at > NOW()`, invitationAttributes)
+	var i *Invitation
+	err := r.acrossTenants(ctx, func(q querier) (err error) {
+		i, err = scanInvitation(q.QueryRowContext(ctx, query, tokenHash))
+		return err
+	})
+	return i, err
+}
+
+func (r *PostgresStore) GetPendingInvitations(ctx context.Context) ([]*Invitation, error) {
+	var invitations []*Invitation
+	err := r.inTenant(ctx, func(q querier, tenantID string) error {
+		query := fmt.Sprintf(`
+            SELECT %s FROM invitations i JOIN organizations o ON o.id = i.org_id
+            WHERE i.org_id = $1 AND i.expires_at > NOW() ORDER BY i.created_at DESC`, invitationAttributes)
+		rows, err := q.QueryContext(ctx, query, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			i, err := scanInvitation(rows)
+			if err != nil {
+				return err
+			}
+			invitations = append(invitations, i)
+		}
+		return rows.Err()
+	})
+	return invitations, err
+}
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	i := &Invitation{}
+	if err := row.Scan(
+		&i.ID, &i.OrgID, &i.OrgName, &i.Email, &i.Role, &i.TokenHash, &i.InvitedBy, &i.CreatedAt, &i.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// AcceptInvitation turns the invitation into a membership of userID. A user
+// who is already a member keeps their current role. It returns
+// sql.ErrNoRows when the invitation is gone or expired.
+func (r *PostgresStore) AcceptInvitation(ctx context.Context, id, userID string) error {
+	return r.acrossTenants(ctx, func(q querier) error {
+		var orgID, role string
+		if err := q.QueryRowContext(ctx,
+			`DELETE FROM invitations WHERE id = $1 AND expires_at > NOW() RETURNING org_id, role`, id,
+		).Scan(&orgID, &role); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, `
+            INSERT INTO memberships (user_id, org_id, role) VALUES ($1, $2, $3)
+            ON CONFLICT (user_id, org_id) DO NOTHING`, userID, orgID, role)
+		return err
+	})
+}
+
+func (r *PostgresStore) DeleteInvitation(ctx context.Context, id string) error {
+	return r.inTenant(ctx, func(q querier, tenantID string) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM invitations WHERE org_id = $1 AND id = $2`, tenantID, id)
+		return err
+	})
+}
+
+func (r *PostgresStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	var deleted int64
+	err := r.acrossTenants(ctx, func(q querier) error {
+		res, err := q.ExecContext(ctx, `DELETE FROM invitations WHERE expires_at < NOW()`)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const lockoutAttributes = "scope, subject, failures, last_failure_at, locked_until"
+
+// Lockout counts consecutive failures for a subject (an email for logins, a
+// user id for OTP checks) within a scope.
+type Lockout struct {
+	Scope         string
+	Subject       string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// LockoutPolicy describes when a subject gets locked: from Threshold
+// consecutive failures on, each new failure locks it for Base doubled per
+// extra failure, capped at Max. Counters restart after Reset without failure.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Reset     time.Duration
+}
+
+// LockDuration is how long the failures-th consecutive failure locks the
+// subject for, 0 below Threshold.
+func (p LockoutPolicy) LockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+type LockoutStore interface {
+	GetLockout(ctx context.Context, scope, subject string) (*Lockout, error)
+	RecordFailure(ctx context.Context, scope, subject string, p LockoutPolicy) (*Lockout, error)
+	ClearLockout(ctx context.Context, scope, subject string) error
+	GetLockouts(ctx context.Context) ([]*Lockout, error)
+}
+
+func (r *PostgresStore) GetLockout(ctx context.Context, scope, subject string) (*Lockout, error) {
+	l := &Lockout{}
+	query := fmt.Sprintf(`SELECT %s FROM login_attempts WHERE scope = $1 AND subject = $2`, lockoutAttributes)
+	if err := r.DB.QueryRowContext(ctx, query, scope, subject).Scan(
+		&l.Scope, &l.Subject, &l.Failures, &l.LastFailureAt, &l.LockedUntil,
+	); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// RecordFailure increments the counter and applies the lock in a single
+// transaction so concurrent replicas see a consistent count.
+func (r *PostgresStore) RecordFailure(ctx context.Context, scope, subject string, p LockoutPolicy) (*Lockout, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	if err := tx.QueryRowContext(ctx, `
+        INSERT INTO login_attempts (scope, subject, failures, last_failure_at)
+        VALUES ($1, $2, 1, NOW())
+        ON CONFLICT (scope, subject) DO UPDATE SET
+            failures = CASE
+                WHEN login_attempts.last_failure_at < NOW() - $3 * INTERVAL '1 second' THEN 1
+                ELSE login_attempts.failures + 1
+            END,
+            last_failure_at = NOW()
+        RETURNING failures`,
+		scope, subject, p.Reset.Seconds(),
+	).Scan(&failures); err != nil {
+		return nil, err
+	}
+
+	// NULL lifts the lock below the threshold.
+	var lockSeconds any
+	if d := p.LockDuration(failures); d > 0 {
+		lockSeconds = d.Seconds()
+	}
+	l := &Lockout{}
+	query := fmt.Sprintf(`
+        UPDATE login_attempts SET locked_until = NOW() + $3 * INTERVAL '1 second'
+        WHERE scope = $1 AND subject = $2
+        RETURNING %s`, lockoutAttributes)
+	if err := tx.QueryRowContext(ctx, query, scope, subject, lockSeconds).Scan(
+		&l.Scope, &l.Subject, &l.Failures, &l.LastFailureAt, &l.LockedUntil,
+	); err != nil {
+		return nil, err
+	}
+
+	return l, tx.Commit()
+}
+
+func (r *PostgresStore) ClearLockout(ctx context.Context, scope, subject string) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM login_attempts WHERE scope = $1 AND subject = $2`, scope, subject)
+	return err
+}
+
+// GetLockouts returns the subjects that are currently locked.
+func (r *PostgresStore) GetLockouts(ctx context.Context) ([]*Lockout, error) {
+	query := fmt.Sprintf(`SELECT %s FROM login_attempts WHERE locked_until > NOW() ORDER BY locked_until DESC`, lockoutAttributes)
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []*Lockout
+	for rows.Next() {
+		l := &Lockout{}
+		if err := rows.Scan(&l.Scope, &l.Subject, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const magicLinkAttributes = "id, user_id, token_hash, created_at, expires_at, used"
+
+type MagicLink struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type MagicLinkStore interface {
+	CreateMagicLink(ctx context.Context, l *MagicLink) error
+	GetLatestMagicLink(ctx context.Context, userID string) (*MagicLink, error)
+	ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error)
+	DeleteMagicLink(ctx context.Context, id string) error
+	DeleteExpiredMagicLinks(ctx context.Context) (int64, error)
+}
+
+// CreateMagicLink stores a new link and invalidates the user's earlier ones.
+func (r *PostgresStore) CreateMagicLink(ctx context.Context, l *MagicLink) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE magic_links SET used = true WHERE user_id = $1 AND NOT used`, l.UserID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO magic_links (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, l.UserID, l.TokenHash, l.CreatedAt, l.ExpiresAt).Scan(&l.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresStore) GetLatestMagicLink(ctx context.Context, userID string) (*MagicLink, error) {
+	l := &MagicLink{}
+	query := fmt.Sprintf(`SELECT %s FROM magic_links WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`, magicLinkAttributes)
+	if err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&l.ID, &l.UserID, &l.TokenHash, &l.CreatedAt, &l.ExpiresAt, &l.Used,
+	); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ConsumeMagicLink marks an unused, unexpired link as used and returns its
+// user id. It returns sql.ErrNoRows when no such link exists.
+func (r *PostgresStore) ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	var userID string
+	query := `UPDATE magic_links SET used = true WHERE token_hash = $1 AND NOT used AND expires_at > NOW() RETURNING user_id`
+	if err := r.DB.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (r *PostgresStore) DeleteMagicLink(ctx context.Context, id string) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM magic_links WHERE id = $1`, id)
+	return err
+}
+
+func (r *PostgresStore) DeleteExpiredMagicLinks(ctx context.Context) (int64, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM magic_links WHERE used OR expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
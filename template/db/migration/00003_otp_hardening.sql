@@ -0,0 +1,29 @@
+-- +goose Up
+-- Pending plaintext codes are only valid for a few minutes, dropping them is safe.
+DROP TABLE IF EXISTS otps;
+
+CREATE TABLE otps (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false
+);
+
+-- At most one usable code per user.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_otps_active_user ON otps(user_id) WHERE NOT used;
+CREATE INDEX IF NOT EXISTS idx_otps_expires_at ON otps(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_sessions_expires_at;
+DROP TABLE IF EXISTS otps;
+
+CREATE TABLE otps (
+    code INTEGER,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    used BOOLEAN default false
+);
@@ -0,0 +1,67 @@
+-- +goose Up
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description, builtin) VALUES
+    ('admin', 'Accès complet', true),
+    ('support', 'Support client', true),
+    ('auditor', 'Lecture seule', true),
+    ('user', 'Utilisateur', true);
+
+INSERT INTO permissions (name, description) VALUES
+    ('admin:access', 'Accéder à l''administration'),
+    ('users:read', 'Consulter les utilisateurs'),
+    ('users:write', 'Modifier les utilisateurs'),
+    ('roles:read', 'Consulter les rôles'),
+    ('roles:write', 'Modifier les rôles'),
+    ('lockouts:read', 'Consulter les comptes verrouillés'),
+    ('lockouts:write', 'Déverrouiller les comptes');
+
+INSERT INTO role_permissions (role, permission)
+    SELECT 'admin', name FROM permissions;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('support', 'admin:access'),
+    ('support', 'users:read'),
+    ('support', 'users:write'),
+    ('support', 'lockouts:read'),
+    ('support', 'lockouts:write'),
+    ('auditor', 'admin:access'),
+    ('auditor', 'users:read'),
+    ('auditor', 'roles:read'),
+    ('auditor', 'lockouts:read');
+
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE TEXT USING role::TEXT;
+UPDATE users SET role = 'user' WHERE role IS NULL;
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'user';
+ALTER TABLE users ALTER COLUMN role SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE;
+DROP TYPE role;
+
+-- +goose Down
+CREATE TYPE role AS ENUM ('admin', 'user');
+ALTER TABLE users DROP CONSTRAINT fk_users_role;
+ALTER TABLE users ALTER COLUMN role DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+UPDATE users SET role = 'user' WHERE role NOT IN ('admin', 'user');
+ALTER TABLE users ALTER COLUMN role TYPE role USING role::role;
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'user'::role;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE sessions ADD COLUMN impersonator_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Se connecter en tant qu''un utilisateur');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:impersonate'),
+    ('support', 'users:impersonate');
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'users:impersonate';
+DELETE FROM sessions WHERE impersonator_id IS NOT NULL;
+ALTER TABLE sessions DROP COLUMN IF EXISTS impersonator_id;
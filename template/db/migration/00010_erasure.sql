@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN erasure_scheduled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_erasure_scheduled_at ON users(erasure_scheduled_at) WHERE erasure_scheduled_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_erasure_scheduled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS erasure_scheduled_at;
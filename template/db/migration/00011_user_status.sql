@@ -0,0 +1,33 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'suspended', 'deleted'));
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+
+UPDATE users SET status = 'suspended', suspended_at = disabled_at WHERE disabled_at IS NOT NULL;
+ALTER TABLE users DROP COLUMN disabled_at;
+
+-- The unique indexes only cover accounts that are not deleted, so that
+-- soft-deleted ones can wait for their erasure or restore without
+-- conflicting with each other. Their email stays reserved until then, but
+-- the service layer enforces it (see checkEmailAvailable), not these
+-- indexes.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_google_id_key;
+CREATE UNIQUE INDEX users_email_key ON users(email) WHERE status <> 'deleted';
+CREATE UNIQUE INDEX users_google_id_key ON users(google_id) WHERE status <> 'deleted';
+CREATE INDEX IF NOT EXISTS idx_users_status ON users(status);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_status;
+DROP INDEX IF EXISTS users_google_id_key;
+DROP INDEX IF EXISTS users_email_key;
+DELETE FROM users WHERE status = 'deleted';
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_google_id_key UNIQUE (google_id);
+
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMPTZ;
+UPDATE users SET disabled_at = suspended_at WHERE status = 'suspended';
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN suspended_at;
+ALTER TABLE users DROP COLUMN status;
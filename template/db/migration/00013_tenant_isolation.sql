@@ -0,0 +1,38 @@
+-- +goose Up
+-- The organization a transaction works for, or NULL outside of tenant-scoped
+-- queries (sign-in, invitations, background jobs).
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION current_tenant_id() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.tenant_id', true), '')::UUID
+$$ LANGUAGE SQL STABLE;
+-- +goose StatementEnd
+
+-- enable_tenant_isolation hides the rows of other tenants from a transaction
+-- that set app.tenant_id. Call it from the migration of every table holding
+-- tenant data.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION enable_tenant_isolation(tbl REGCLASS, col TEXT DEFAULT 'org_id') RETURNS VOID AS $$
+BEGIN
+    EXECUTE format('ALTER TABLE %s ENABLE ROW LEVEL SECURITY', tbl);
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %s', tbl);
+    EXECUTE format(
+        'CREATE POLICY tenant_isolation ON %s USING (current_tenant_id() IS NULL OR %I = current_tenant_id())',
+        tbl, col
+    );
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+SELECT enable_tenant_isolation('organizations', 'id');
+SELECT enable_tenant_isolation('memberships');
+SELECT enable_tenant_isolation('invitations');
+
+-- +goose Down
+DROP POLICY IF EXISTS tenant_isolation ON invitations;
+DROP POLICY IF EXISTS tenant_isolation ON memberships;
+DROP POLICY IF EXISTS tenant_isolation ON organizations;
+ALTER TABLE invitations DISABLE ROW LEVEL SECURITY;
+ALTER TABLE memberships DISABLE ROW LEVEL SECURITY;
+ALTER TABLE organizations DISABLE ROW LEVEL SECURITY;
+DROP FUNCTION IF EXISTS enable_tenant_isolation(REGCLASS, TEXT);
+DROP FUNCTION IF EXISTS current_tenant_id();
@@ -0,0 +1,48 @@
+-- +goose Up
+-- Rows are hidden unless the transaction set app.tenant_id to their tenant,
+-- so that a query forgetting the tenant sees nothing instead of everything.
+-- The queries that are not about one organization (listing a user's
+-- organizations, following an invitation, the janitor) set app.tenant_bypass
+-- for their transaction. Connections that must see every tenant outside of
+-- the application, such as reporting, use a role with BYPASSRLS.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION tenant_bypass() RETURNS BOOLEAN AS $$
+    SELECT COALESCE(current_setting('app.tenant_bypass', true), '') = 'on'
+$$ LANGUAGE SQL STABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION enable_tenant_isolation(tbl REGCLASS, col TEXT DEFAULT 'org_id') RETURNS VOID AS $$
+BEGIN
+    EXECUTE format('ALTER TABLE %s ENABLE ROW LEVEL SECURITY', tbl);
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %s', tbl);
+    EXECUTE format(
+        'CREATE POLICY tenant_isolation ON %s USING (%I = current_tenant_id() OR tenant_bypass())',
+        tbl, col
+    );
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+SELECT enable_tenant_isolation('organizations', 'id');
+SELECT enable_tenant_isolation('memberships');
+SELECT enable_tenant_isolation('invitations');
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION enable_tenant_isolation(tbl REGCLASS, col TEXT DEFAULT 'org_id') RETURNS VOID AS $$
+BEGIN
+    EXECUTE format('ALTER TABLE %s ENABLE ROW LEVEL SECURITY', tbl);
+    EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %s', tbl);
+    EXECUTE format(
+        'CREATE POLICY tenant_isolation ON %s USING (current_tenant_id() IS NULL OR %I = current_tenant_id())',
+        tbl, col
+    );
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+SELECT enable_tenant_isolation('organizations', 'id');
+SELECT enable_tenant_isolation('memberships');
+SELECT enable_tenant_isolation('invitations');
+DROP FUNCTION IF EXISTS tenant_bypass();
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Whether the user proved they receive the mail of their address: through
+-- Google, a magic link or the confirmation of an email change.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE WHERE google_id IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN email_verified;
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Roles a user can hold within an organization.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ErrLastOwner is returned by the membership changes that would leave an
+// organization without an owner.
+var ErrLastOwner = errors.New("an organization needs at least one owner")
+
+type Organization struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Membership links a user to an organization. OrgName and Email are filled
+// in by the listing queries.
+type Membership struct {
+	UserID    string
+	OrgID     string
+	OrgName   string
+	Email     string
+	Role      string
+	CreatedAt time.Time
+}
+
+type OrganizationStore interface {
+	CreateOrganization(ctx context.Context, o *Organization, ownerID string) error
+	GetUserMemberships(ctx context.Context, userID string) ([]*Membership, error)
+
+	// Scoped to the tenant of ctx, see WithTenant.
+	GetMembership(ctx context.Context, userID string) (*Membership, error)
+	GetMembers(ctx context.Context) ([]*Membership, error)
+	UpdateMembershipRole(ctx context.Context, userID, role string) error
+	DeleteMembership(ctx context.Context, userID string) error
+}
+
+// CreateOrganization creates o with ownerID as its first owner.
+func (r *PostgresStore) CreateOrganization(ctx context.Context, o *Organization, ownerID string) error {
+	return r.acrossTenants(ctx, func(q querier) error {
+		if err := q.QueryRowContext(ctx,
+			`INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at`, o.Name,
+		).Scan(&o.ID, &o.CreatedAt); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx,
+			`INSERT INTO memberships (user_id, org_id, role) VALUES ($1, $2, $3)`, ownerID, o.ID, OrgRoleOwner)
+		return err
+	})
+}
+
+// GetUserMemberships lists the organizations of userID, by name.
+func (r *PostgresStore) GetUserMemberships(ctx context.Context, userID string) ([]*Membership, error) {
+	var memberships []*Membership
+	err := r.acrossTenants(ctx, func(q querier) (err error) {
+		memberships, err = queryMemberships(ctx, q, `
+            SELECT m.user_id, m.org_id, o.name, u.email, m.role, m.created_at
+            FROM memberships m JOIN organizations o ON o.id = m.org_id JOIN users u ON u.id = m.user_id
+            WHERE m.user_id = $1 ORDER BY o.name`, userID)
+		return err
+	})
+	return memberships, err
+}
+
+func (r *PostgresStore) GetMembership(ctx context.Context, userID string) (*Membership, error) {
+	m := &Membership{}
+	err := r.inTenant(ctx, func(q querier, tenantID string) error {
+		return q.QueryRowContext(ctx, `
+            SELECT m.user_id, m.org_id, o.name, u.email, m.role, m.created_at
+            FROM memberships m JOIN organizations o ON o.id = m.org_id JOIN users u ON u.id = m.user_id
+            WHERE m.org_id = $1 AND m.user_id = $2`, tenantID, userID,
+		).Scan(&m.UserID, &m.OrgID, &m.OrgName, &m.Email, &m.Role, &m.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetMembers lists the members of the tenant who have not been deleted,
+// owners first.
+func (r *PostgresStore) GetMembers(ctx context.Context) ([]*Membership, error) {
+	var members []*Membership
+	err := r.inTenant(ctx, func(q querier, tenantID string) (err error) {
+		members, err = queryMemberships(ctx, q, `
+            SELECT m.user_id, m.org_id, o.name, u.email, m.role, m.created_at
+            FROM memberships m JOIN organizations o ON o.id = m.org_id JOIN users u ON u.id = m.user_id
+            WHERE m.org_id = $1 AND u.`+notDeleted+`
+            ORDER BY m.role = 'owner' DESC, u.email`, tenantID)
+		return err
+	})
+	return members, err
+}
+
+func queryMemberships(ctx context.Context, q querier, query string, args ...any) ([]*Membership, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []*Membership
+	for rows.Next() {
+		m := &Membership{}
+		if err := rows.Scan(&m.UserID, &m.OrgID, &m.OrgName, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// UpdateMembershipRole fails with ErrLastOwner when it would demote the
+// only owner.
+func (r *PostgresStore) UpdateMembershipRole(ctx context.Context, userID, role string) error {
+	return r.inTenant(ctx, func(q querier, tenantID string) error {
+		if role != OrgRoleOwner {
+			if err := keepAnOwner(ctx, q, tenantID, userID); err != nil {
+				return err
+			}
+		}
+		_, err := q.ExecContext(ctx, `UPDATE memberships SET role = $1 WHERE org_id = $2 AND user_id = $3`, role, tenantID, userID)
+		return err
+	})
+}
+
+// DeleteMembership fails with ErrLastOwner when userID is the only owner.
+func (r *PostgresStore) DeleteMembership(ctx context.Context, userID string) error {
+	return r.inTenant(ctx, func(q querier, tenantID string) error {
+		if err := keepAnOwner(ctx, q, tenantID, userID); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, `DELETE FROM memberships WHERE org_id = $1 AND user_id = $2`, tenantID, userID)
+		return err
+	})
+}
+
+// keepAnOwner fails with ErrLastOwner when userID is the only owner of
+// tenantID. Owners whose account is deleted or due for erasure do not
+// count, as they will never manage the organization again. The owners and
+// their accounts stay locked until the end of the transaction, so that two
+// owners stepping down at once cannot both see the other one.
+func keepAnOwner(ctx context.Context, q querier, tenantID, userID string) error {
+	rows, err := q.QueryContext(ctx, `
+        SELECT m.user_id, u.`+notDeleted+` AND u.erasure_scheduled_at IS NULL
+        FROM memberships m JOIN users u ON u.id = m.user_id
+        WHERE m.org_id = $1 AND m.role = $2
+        FOR UPDATE OF m FOR SHARE OF u`, tenantID, OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	owner, others := false, 0
+	for rows.Next() {
+		var id string
+		var staying bool
+		if err := rows.Scan(&id, &staying); err != nil {
+			return err
+		}
+		if id == userID {
+			owner = true
+		} else if staying {
+			others++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if owner && others == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// checkOwnerships checks that userID can leave every organization it owns
+// at once, and returns those it is the only member of. It fails with
+// ErrLastOwner when userID is the only owner of one that has other
+// members. The owners stay locked as in keepAnOwner.
+func checkOwnerships(ctx context.Context, q querier, userID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT org_id FROM memberships WHERE user_id = $1 AND role = $2`, userID, OrgRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	var owned []string
+	for rows.Next() {
+		var orgID string
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		owned = append(owned, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var alone []string
+	for _, orgID := range owned {
+		err := keepAnOwner(ctx, q, orgID, userID)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrLastOwner) {
+			return nil, err
+		}
+		var members int
+		if err := q.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM memberships WHERE org_id = $1 AND user_id <> $2`, orgID, userID,
+		).Scan(&members); err != nil {
+			return nil, err
+		}
+		if members > 0 {
+			return nil, ErrLastOwner
+		}
+		alone = append(alone, orgID)
+	}
+	return alone, nil
+}
@@ -6,50 +6,77 @@ import (
 	"time"
 )
 
-const (
-	otpAttributes = "user_id, code, created_at, used"
-	expiryTime    = 5 * time.Minute
-)
+const otpAttributes = "id, user_id, code_hash, attempts, created_at, expires_at, used"
 
 type Otp struct {
-	Code      int
+	ID        string
 	UserId    string
+	CodeHash  string
+	Attempts  int
 	CreatedAt time.Time
+	ExpiresAt time.Time
 	Used      bool
 }
 
 type OtpStore interface {
 	CreateOtp(ctx context.Context, otp *Otp) error
-	GetOtp(ctx context.Context, userId string, code int) (*Otp, error)
-	MarkOtpAsUsed(ctx context.Context, userId string, code int) error
-	DeleteExpiredOtps(ctx context.Context) error
+	GetActiveOtp(ctx context.Context, userId string) (*Otp, error)
+	IncrementOtpAttempts(ctx context.Context, id string) error
+	MarkOtpAsUsed(ctx context.Context, id string) (bool, error)
+	DeleteExpiredOtps(ctx context.Context) (int64, error)
 }
 
+// CreateOtp stores a new code and invalidates every code issued before it,
+// so only the most recent one can be used.
 func (r *PostgresStore) CreateOtp(ctx context.Context, otp *Otp) error {
-	query := fmt.Sprintf(`INSERT INTO otps (%s) VALUES ($1, $2, $3, $4)`, otpAttributes)
-	_, err := r.DB.ExecContext(ctx, query, otp.UserId, otp.Code, otp.CreatedAt, false)
-	return err
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE otps SET used = true WHERE user_id = $1 AND NOT used`, otp.UserId); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO otps (user_id, code_hash, created_at, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, otp.UserId, otp.CodeHash, otp.CreatedAt, otp.ExpiresAt).Scan(&otp.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostgresStore) GetOtp(ctx context.Context, userId string, code int) (*Otp, error) {
+func (r *PostgresStore) GetActiveOtp(ctx context.Context, userId string) (*Otp, error) {
 	otp := &Otp{}
-	query := fmt.Sprintf(`SELECT %s FROM otps WHERE user_id = $1 AND code = $2`, otpAttributes)
-	if err := r.DB.QueryRowContext(ctx, query, userId, code).Scan(&otp.UserId, &otp.Code, &otp.CreatedAt, &otp.Used); err != nil {
+	query := fmt.Sprintf(`SELECT %s FROM otps WHERE user_id = $1 AND NOT used`, otpAttributes)
+	if err := r.DB.QueryRowContext(ctx, query, userId).Scan(
+		&otp.ID, &otp.UserId, &otp.CodeHash, &otp.Attempts, &otp.CreatedAt, &otp.ExpiresAt, &otp.Used,
+	); err != nil {
 		return nil, err
 	}
 	return otp, nil
 }
 
-func (r *PostgresStore) MarkOtpAsUsed(ctx context.Context, userId string, code int) error {
-	query := `UPDATE otps SET used = true WHERE user_id = $1 AND code = $2`
-	if _, err := r.DB.ExecContext(ctx, query, userId, code); err != nil {
-		return err
+func (r *PostgresStore) IncrementOtpAttempts(ctx context.Context, id string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE otps SET attempts = attempts + 1 WHERE id = $1`, id)
+	return err
+}
+
+// MarkOtpAsUsed reports false when the code was already consumed, which
+// makes concurrent submissions of the same code fail for all but one.
+func (r *PostgresStore) MarkOtpAsUsed(ctx context.Context, id string) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `UPDATE otps SET used = true WHERE id = $1 AND NOT used`, id)
+	if err != nil {
+		return false, err
 	}
-	return nil
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
-func (r *PostgresStore) DeleteExpiredOtps(ctx context.Context) error {
-	query := `DELETE FROM otps WHERE created_at < $1`
-	_, err := r.DB.ExecContext(ctx, query, time.Now().Add(-expiryTime))
-	return err
+func (r *PostgresStore) DeleteExpiredOtps(ctx context.Context) (int64, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM otps WHERE used OR expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const roleAttributes = "name, description, builtin, created_at"
+
+type Role struct {
+	Name        string
+	Description string
+	Builtin     bool
+	CreatedAt   time.Time
+	Permissions []string
+}
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+type RoleStore interface {
+	GetRoles(ctx context.Context) ([]*Role, error)
+	GetPermissions(ctx context.Context) ([]*Permission, error)
+	GetRolePermissions(ctx context.Context, role string) ([]string, error)
+	CreateRole(ctx context.Context, r *Role) error
+	SetRolePermissions(ctx context.Context, role string, permissions []string) error
+	DeleteRole(ctx context.Context, role string) (bool, error)
+}
+
+// GetRoles returns every role with its permissions.
+func (r *PostgresStore) GetRoles(ctx context.Context) ([]*Role, error) {
+	query := fmt.Sprintf(`
+        SELECT %s, COALESCE(ARRAY_AGG(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+        FROM roles LEFT JOIN role_permissions rp ON rp.role = roles.name
+        GROUP BY roles.name ORDER BY roles.builtin DESC, roles.name`, roleAttributes)
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		role := &Role{}
+		if err := rows.Scan(
+			&role.Name, &role.Description, &role.Builtin, &role.CreatedAt, pq.Array(&role.Permissions),
+		); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (r *PostgresStore) GetPermissions(ctx context.Context) ([]*Permission, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*Permission
+	for rows.Next() {
+		p := &Permission{}
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+func (r *PostgresStore) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	var permissions []string
+	query := `SELECT COALESCE(ARRAY_AGG(permission), '{}') FROM role_permissions WHERE role = $1`
+	if err := r.DB.QueryRowContext(ctx, query, role).Scan(pq.Array(&permissions)); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (r *PostgresStore) CreateRole(ctx context.Context, role *Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`INSERT INTO roles (name, description) VALUES ($1, $2) RETURNING %s`, roleAttributes)
+	if err := tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(
+		&role.Name, &role.Description, &role.Builtin, &role.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if err := insertRolePermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRolePermissions replaces the permissions granted to role.
+func (r *PostgresStore) SetRolePermissions(ctx context.Context, role string, permissions []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = $1`, role); err != nil {
+		return err
+	}
+	if err := insertRolePermissions(ctx, tx, role, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertRolePermissions(ctx context.Context, tx *sql.Tx, role string, permissions []string) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO role_permissions (role, permission)
+        SELECT $1, name FROM permissions WHERE name = ANY($2)`,
+		role, pq.Array(permissions))
+	return err
+}
+
+// DeleteRole removes a role that is neither builtin nor assigned to a user
+// and reports whether it did.
+func (r *PostgresStore) DeleteRole(ctx context.Context, role string) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `
+        DELETE FROM roles WHERE name = $1 AND NOT builtin
+        AND NOT EXISTS (SELECT 1 FROM users WHERE role = $1)`, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
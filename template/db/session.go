@@ -2,20 +2,24 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"net"
 	"time"
 )
 
-const sessionAttributes = "user_id, token,created_at,expires_at, ip_address, user_agent "
+const sessionAttributes = "user_id, token,created_at,expires_at, ip_address, user_agent, impersonator_id "
 
+// Session is a signed-in browser. ImpersonatorID is set when an admin
+// opened the session to act as UserID.
 type Session struct {
-	UserID    string
-	Token     string
-	CreatedAt time.Time
-	ExpiresAt *time.Time
-	IPAddress net.IP
-	UserAgent string
+	UserID         string
+	Token          string
+	CreatedAt      time.Time
+	ExpiresAt      *time.Time
+	IPAddress      net.IP
+	UserAgent      string
+	ImpersonatorID string
 }
 
 type SessionStore interface {
@@ -24,26 +28,44 @@ type SessionStore interface {
 	DeleteByCookieHash(ctx context.Context, cookieHash string) error
 	UpdateExpiry(ctx context.Context, cookieHash string, expiresAt time.Time) error
 	DeleteByUserID(ctx context.Context, userID string) error
+	DeleteOtherSessions(ctx context.Context, userID, keepToken string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+	GetSessionsByUserID(ctx context.Context, userID string) ([]Session, error)
 }
 
 func (ss *PostgresStore) CreateSession(ctx context.Context, s Session) (string, error) {
 	var cookieHash string
-	query := fmt.Sprintf(`INSERT INTO sessions (%s) VALUES ($1, $2, NOW(), $3, $4,$5) RETURNING token`, sessionAttributes)
-	if err := ss.DB.QueryRowContext(ctx, query, s.UserID, s.Token, s.ExpiresAt.UTC(), s.IPAddress.String(), s.UserAgent).Scan(&cookieHash); err != nil {
+	query := fmt.Sprintf(`INSERT INTO sessions (%s) VALUES ($1, $2, NOW(), $3, $4,$5, NULLIF($6, '')::UUID) RETURNING token`, sessionAttributes)
+	if err := ss.DB.QueryRowContext(ctx, query,
+		s.UserID, s.Token, s.ExpiresAt.UTC(), s.IPAddress.String(), s.UserAgent, s.ImpersonatorID,
+	).Scan(&cookieHash); err != nil {
 		return "", err
 	}
 	return cookieHash, nil
 }
 
 func (ss *PostgresStore) GetByCookieHash(ctx context.Context, cookieHash string) (Session, error) {
-	var s Session
 	query := fmt.Sprintf(`SELECT %s FROM sessions WHERE token = $1`, sessionAttributes)
-	if err := ss.DB.QueryRowContext(ctx, query, cookieHash).Scan(&s.UserID, &s.Token, &s.CreatedAt, &s.ExpiresAt, &s.IPAddress, &s.UserAgent); err != nil {
+	s, err := scanSession(ss.DB.QueryRowContext(ctx, query, cookieHash))
+	if err != nil {
 		return Session{}, err
 	}
 	return s, nil
 }
 
+// scanSession reads the columns listed in sessionAttributes.
+func scanSession(row rowScanner) (Session, error) {
+	var s Session
+	var ip, userAgent, impersonatorID sql.NullString
+	if err := row.Scan(&s.UserID, &s.Token, &s.CreatedAt, &s.ExpiresAt, &ip, &userAgent, &impersonatorID); err != nil {
+		return Session{}, err
+	}
+	s.IPAddress = net.ParseIP(ip.String)
+	s.UserAgent = userAgent.String
+	s.ImpersonatorID = impersonatorID.String
+	return s, nil
+}
+
 func (ss *PostgresStore) DeleteByCookieHash(ctx context.Context, cookieHash string) error {
 	_, err := ss.DB.ExecContext(ctx, `DELETE FROM sessions WHERE token = $1`, cookieHash)
 	return err
@@ -58,3 +80,36 @@ func (ss *PostgresStore) DeleteByUserID(ctx context.Context, userID string) erro
 	_, err := ss.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
 	return err
 }
+
+// DeleteOtherSessions signs userID out everywhere but the session keepToken.
+func (ss *PostgresStore) DeleteOtherSessions(ctx context.Context, userID, keepToken string) error {
+	_, err := ss.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND token <> $2`, userID, keepToken)
+	return err
+}
+
+func (ss *PostgresStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	res, err := ss.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (ss *PostgresStore) GetSessionsByUserID(ctx context.Context, userID string) ([]Session, error) {
+	query := fmt.Sprintf(`SELECT %s FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY created_at DESC`, sessionAttributes)
+	rows, err := ss.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
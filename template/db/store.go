@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 )
 
 type PostgresStore struct {
@@ -22,4 +23,17 @@ type AuthStore interface {
 	SessionStore
 	UserStore
 	OtpStore
+	LockoutStore
+	MagicLinkStore
+	EmailChangeStore
+	OrganizationStore
+	InvitationStore
+	APITokenStore
+	RoleStore
+	AuditStore
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrNoTenant is returned by tenant-scoped methods when the context carries
+// no tenant.
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx scoped to the organization tenantID.
+// Tenant-scoped store methods only read and write that organization's rows.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTenant runs fn in a transaction for the tenant of ctx. Queries in fn
+// must still filter on tenantID; app.tenant_id is set for the duration of
+// the transaction so that the row-level security policies catch a query
+// that forgets to. The setting is local to the transaction and never leaks
+// to the next user of the pooled connection.
+func (r *PostgresStore) inTenant(ctx context.Context, fn func(q querier, tenantID string) error) error {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return ErrNoTenant
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true)`, tenantID); err != nil {
+		return err
+	}
+	if err := fn(tx, tenantID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// acrossTenants runs fn in a transaction that sees the rows of every
+// tenant, for the queries that are not about a single organization. The
+// row-level security policies hide every tenant row from queries run in
+// neither inTenant nor acrossTenants.
+func (r *PostgresStore) acrossTenants(ctx context.Context, fn func(q querier) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_bypass', 'on', true)`); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnforceRowLevelSecurity applies the tenant_isolation policies to the table
+// owner, which the application usually connects as and which Postgres
+// otherwise exempts. Tables get a policy from their migration with
+// SELECT enable_tenant_isolation('table').
+//
+// Only the tables not forced yet are altered, which takes the rights of
+// their owner: under a least-privilege role, have them forced beforehand
+// and nothing is changed at startup. Nothing is done when enforce is false;
+// lift the enforcement with ALTER TABLE ... NO FORCE ROW LEVEL SECURITY.
+func EnforceRowLevelSecurity(ctx context.Context, db *sql.DB, enforce bool) error {
+	if !enforce {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT DISTINCT p.schemaname, p.tablename
+        FROM pg_policies p
+        JOIN pg_namespace n ON n.nspname = p.schemaname
+        JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = p.tablename
+        WHERE p.policyname = 'tenant_isolation' AND NOT c.relforcerowsecurity`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(table))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table)); err != nil {
+			return fmt.Errorf("failed to set row level security on %s: %w", table, err)
+		}
+	}
+	return nil
+}
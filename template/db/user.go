@@ -7,18 +7,39 @@ import (
 	"time"
 )
 
-const userAttribute = "id,email,password_hash,google_id,oauth,verify,role,created_at,updated_at"
+const userAttribute = "id,email,password_hash,google_id,oauth,verify,role,created_at,updated_at,status,suspended_at,deleted_at,must_change_password,erasure_scheduled_at,email_verified"
+
+// Account states. Suspended accounts keep their data but cannot sign in;
+// deleted ones are hidden from every UserStore query unless stated
+// otherwise, and erased once their grace period is over.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDeleted   = "deleted"
+)
+
+// notDeleted is the condition every user query applies by default.
+const notDeleted = "status <> 'deleted'"
 
 type User struct {
-	ID           string
-	Email        string
-	PasswordHash string
-	GoogleID     string
-	Oauth        bool
-	Verify       bool
-	Role         string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID                 string
+	Email              string
+	PasswordHash       string
+	HasPassword        bool
+	GoogleID           string
+	Oauth              bool
+	Verify             bool
+	Role               string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Status             string
+	SuspendedAt        *time.Time
+	DeletedAt          *time.Time
+	MustChangePassword bool
+	ErasureScheduledAt *time.Time
+	// EmailVerified is set once the user proved they receive the mail of
+	// Email. Verify is about the admin's OTP instead.
+	EmailVerified bool
 }
 
 type GoogleUser struct {
@@ -36,39 +57,69 @@ type UserStore interface {
 	CreateUser(ctx context.Context, u *User) (*User, error)
 	CreateUserWithGoogle(ctx context.Context, u *User) (*User, error)
 	GetUserByID(ctx context.Context, id string) (*User, error)
+	GetUserByIDIncludingDeleted(ctx context.Context, id string) (*User, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetDeletedUserByEmail(ctx context.Context, email string) (*User, error)
 	GetUserByGoogleID(ctx context.Context, gid string) (*User, error)
 	GetAllUsers(ctx context.Context) ([]*User, error)
+	SearchUsers(ctx context.Context, search, status string, limit, offset int) ([]*User, int, error)
 	UpdateUser(ctx context.Context, u *User) error
 	DeleteUser(ctx context.Context, id string) error
 	GetUserBySessionID(ctx context.Context, sid string) (*User, error)
 	UpdateVerify(ctx context.Context, id string, verify bool) error
+	UpdatePasswordHash(ctx context.Context, id string, hash string) error
+	UpdateEmail(ctx context.Context, id string, email string) error
+	MarkEmailVerified(ctx context.Context, id string) error
+	UpdateUserRole(ctx context.Context, id string, role string) error
+	SetUserStatus(ctx context.Context, id string, status string) error
+	SetMustChangePassword(ctx context.Context, id string, must bool) error
+	SetErasureScheduledAt(ctx context.Context, id string, at *time.Time) error
+	GetUsersDueForErasure(ctx context.Context, before time.Time) ([]*User, error)
+	EraseUser(ctx context.Context, u *User, emails []string, pseudonym string) error
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads the columns listed in userAttribute. password_hash and
+// google_id are NULL for accounts created through Google.
+func scanUser(row rowScanner) (*User, error) {
+	u := &User{}
+	var password, googleID sql.NullString
+	if err := row.Scan(
+		&u.ID,
+		&u.Email,
+		&password,
+		&googleID,
+		&u.Oauth,
+		&u.Verify,
+		&u.Role,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.Status,
+		&u.SuspendedAt,
+		&u.DeletedAt,
+		&u.MustChangePassword,
+		&u.ErasureScheduledAt,
+		&u.EmailVerified,
+	); err != nil {
+		return nil, err
+	}
+	u.PasswordHash = password.String
+	u.HasPassword = password.String != ""
+	u.GoogleID = googleID.String
+	return u, nil
 }
 
 func (r *PostgresStore) CreateUser(ctx context.Context, u *User) (*User, error) {
-	user := &User{}
-	var google_id sql.NullString
 	query := fmt.Sprintf(
 		`INSERT INTO users (email, password_hash, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) RETURNING %s`,
 		userAttribute,
 	)
 
-	if err := r.DB.QueryRowContext(
-		ctx,
-		query,
-		u.Email,
-		u.PasswordHash,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&google_id,
-		&user.Oauth,
-		&user.Verify,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	); err != nil {
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, u.Email, u.PasswordHash))
+	if err != nil {
 		return nil, err
 	}
 	user.GoogleID = ""
@@ -77,119 +128,69 @@ func (r *PostgresStore) CreateUser(ctx context.Context, u *User) (*User, error)
 }
 
 func (r *PostgresStore) CreateUserWithGoogle(ctx context.Context, u *User) (*User, error) {
-	user := &User{}
-	var google_id sql.NullString
-	var password sql.NullString
 	query := fmt.Sprintf(
-		`INSERT INTO users (email, google_id, oauth, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW()) RETURNING %s`,
+		`INSERT INTO users (email, google_id, oauth, email_verified, created_at, updated_at) VALUES ($1, $2, $3, TRUE, NOW(), NOW()) RETURNING %s`,
 		userAttribute,
 	)
-	if err := r.DB.QueryRowContext(
-		ctx,
-		query,
-		u.Email,
-		u.GoogleID,
-		u.Oauth,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&password,
-		&google_id,
-		&user.Oauth,
-		&user.Verify,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	); err != nil {
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, u.Email, u.GoogleID, u.Oauth))
+	if err != nil {
 		return nil, err
 	}
-	user.GoogleID = google_id.String
 	user.PasswordHash = ""
 	return user, nil
 }
 
 func (r *PostgresStore) GetUserByID(ctx context.Context, id string) (*User, error) {
-	user := &User{}
-	var google_id sql.NullString
-	query := fmt.Sprintf(`SELECT %s FROM users WHERE id = $1`, userAttribute)
-	if err := r.DB.QueryRowContext(
-		ctx,
-		query,
-		id,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&google_id,
-		&user.Oauth,
-		&user.Verify,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	); err != nil {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE id = $1 AND %s`, userAttribute, notDeleted)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
 		return nil, err
 	}
+	user.PasswordHash = ""
+	return user, nil
+}
 
-	user.GoogleID = google_id.String
+// GetUserByIDIncludingDeleted is GetUserByID for the admin console, which
+// can look at and restore soft-deleted accounts.
+func (r *PostgresStore) GetUserByIDIncludingDeleted(ctx context.Context, id string) (*User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE id = $1`, userAttribute)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
 	user.PasswordHash = ""
 	return user, nil
 }
 
 func (r *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
-	user := &User{}
-	var google_id sql.NullString
-	query := fmt.Sprintf(`SELECT %s FROM users WHERE email = $1`, userAttribute)
-	if err := r.DB.QueryRowContext(
-		ctx,
-		query,
-		email,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&google_id,
-		&user.Oauth,
-		&user.Verify,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	); err != nil {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE email = $1 AND %s`, userAttribute, notDeleted)
+	return scanUser(r.DB.QueryRowContext(ctx, query, email))
+}
+
+// GetDeletedUserByEmail returns the last soft-deleted account of email,
+// whose address stays reserved until it is erased or restored.
+func (r *PostgresStore) GetDeletedUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE email = $1 AND status = 'deleted' ORDER BY deleted_at DESC LIMIT 1`, userAttribute)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, email))
+	if err != nil {
 		return nil, err
 	}
-
-	user.GoogleID = google_id.String
+	user.PasswordHash = ""
 	return user, nil
 }
 
 func (r *PostgresStore) GetUserByGoogleID(ctx context.Context, gid string) (*User, error) {
-	user := &User{}
-	var google_id sql.NullString
-	query := fmt.Sprintf(`SELECT %s FROM users WHERE google_id = $1`, userAttribute)
-	if err := r.DB.QueryRowContext(
-		ctx,
-		query,
-		gid,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&google_id,
-		&user.Oauth,
-		&user.Verify,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	); err != nil {
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE google_id = $1 AND %s`, userAttribute, notDeleted)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, gid))
+	if err != nil {
 		return nil, err
 	}
-
-	user.GoogleID = google_id.String
 	user.PasswordHash = ""
 	return user, nil
 }
 
 func (r *PostgresStore) GetAllUsers(ctx context.Context) ([]*User, error) {
-	query := fmt.Sprintf(`SELECT %s FROM users`, userAttribute)
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE %s`, userAttribute, notDeleted)
 	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -198,17 +199,51 @@ func (r *PostgresStore) GetAllUsers(ctx context.Context) ([]*User, error) {
 
 	var users []*User
 	for rows.Next() {
-		var google_id sql.NullString
-		u := &User{}
-		if err := rows.Scan(
-			&u.ID, &u.Email, &u.PasswordHash, &google_id, &u.Oauth, &u.Verify, &u.Role, &u.CreatedAt, &u.UpdatedAt,
-		); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
 		u.PasswordHash = ""
 		users = append(users, u)
 	}
-	return users, nil
+	return users, rows.Err()
+}
+
+// SearchUsers returns a page of users whose email contains search, newest
+// first, along with the total number of matches. An empty status matches
+// every account that is not deleted.
+func (r *PostgresStore) SearchUsers(ctx context.Context, search, status string, limit, offset int) ([]*User, int, error) {
+	pattern := "%" + escapeLike(search) + "%"
+	where := "email ILIKE $1 AND " + notDeleted
+	args := []any{pattern}
+	if status != "" {
+		where = "email ILIKE $1 AND status = $2"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE %s ORDER BY created_at DESC LIMIT $%d OFFSET $%d`,
+		userAttribute, where, len(args)+1, len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		u.PasswordHash = ""
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
 }
 
 func (r *PostgresStore) UpdateUser(ctx context.Context, u *User) error {
@@ -224,19 +259,59 @@ func (r *PostgresStore) DeleteUser(ctx context.Context, id string) error {
 }
 
 func (r *PostgresStore) GetUserBySessionID(ctx context.Context, sid string) (*User, error) {
-	row := r.DB.QueryRowContext(ctx, `
-        SELECT u.id, u.email, u.password_hash, u.google_id, u.created_at, u.updated_at
-        FROM users u INNER JOIN sessions s ON u.id = s.user_id WHERE s.session_id = $1`, sid)
-	u := &User{}
-	if err := row.Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
-		return nil, err
-	}
-	return u, nil
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE id = (SELECT user_id FROM sessions WHERE token = $1) AND %s`, userAttribute, notDeleted)
+	return scanUser(r.DB.QueryRowContext(ctx, query, sid))
 }
 
 func (r *PostgresStore) UpdateVerify(ctx context.Context, id string, verify bool) error {
-	_, err := r.DB.ExecContext(ctx, `UPDATE users SET verify = $1 WHERE id = $2`, verify, id)
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET verify = $1 WHERE id = $2 AND `+notDeleted, verify, id)
+	return err
+}
+
+func (r *PostgresStore) UpdatePasswordHash(ctx context.Context, id string, hash string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2 AND `+notDeleted, hash, id)
+	return err
+}
+
+// UpdateEmail replaces the address of the user with email, which they
+// confirmed.
+func (r *PostgresStore) UpdateEmail(ctx context.Context, id string, email string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET email = $1, email_verified = TRUE, updated_at = NOW() WHERE id = $2 AND `+notDeleted, email, id)
+	return err
+}
+
+func (r *PostgresStore) MarkEmailVerified(ctx context.Context, id string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET email_verified = TRUE, updated_at = NOW() WHERE id = $1 AND `+notDeleted, id)
+	return err
+}
+
+func (r *PostgresStore) UpdateUserRole(ctx context.Context, id string, role string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2 AND `+notDeleted, role, id)
+	return err
+}
+
+// SetUserStatus moves the user to status and stamps suspended_at or
+// deleted_at accordingly. Deleting fails with ErrLastOwner while the user
+// is the only owner of an organization that has other members.
+func (r *PostgresStore) SetUserStatus(ctx context.Context, id string, status string) error {
+	return r.acrossTenants(ctx, func(q querier) error {
+		if status == UserStatusDeleted {
+			if _, err := checkOwnerships(ctx, q, id); err != nil {
+				return err
+			}
+		}
+		_, err := q.ExecContext(ctx, `
+            UPDATE users SET status = $1,
+                suspended_at = CASE WHEN $1 = 'suspended' THEN NOW() END,
+                deleted_at = CASE WHEN $1 = 'deleted' THEN NOW() END,
+                updated_at = NOW()
+            WHERE id = $2`,
+			status, id)
+		return err
+	})
+}
+
+func (r *PostgresStore) SetMustChangePassword(ctx context.Context, id string, must bool) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET must_change_password = $1, updated_at = NOW() WHERE id = $2`, must, id)
 	return err
 }
@@ -0,0 +1,327 @@
+// Package form decodes request forms into structs and validates them.
+//
+// Fields are mapped with the form tag and checked with the rules of the
+// validate tag, applied in order until one fails:
+//
+//	type signup struct {
+//		Email    string `form:"email" validate:"required,email,max=255"`
+//		Password string `form:"password,secret" validate:"required,min=8"`
+//		Plan     string `form:"plan" validate:"oneof=free pro"`
+//		Confirm  string `form:"confirm,secret" validate:"eqfield=Password"`
+//	}
+//
+// Values are trimmed of surrounding spaces, except secret fields, which
+// Redact also clears before the form is shown again.
+//
+// Supported field types are string, bool, the integer types and []string.
+// The rules are required, email, min=N and max=N (length for strings and
+// slices, value for integers), oneof=a b c and eqfield=Field.
+package form
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Errors maps the form field names to the first rule they failed.
+type Errors map[string]error
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, err := range e {
+		fields = append(fields, field+": "+err.Error())
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, "; ")
+}
+
+// RuleError is a failed validation rule. Param is the rule's argument, e.g.
+// "8" for min=8.
+type RuleError struct {
+	Rule  string
+	Param string
+}
+
+func (e *RuleError) Error() string {
+	if e.Param == "" {
+		return "failed rule " + e.Rule
+	}
+	return "failed rule " + e.Rule + "=" + e.Param
+}
+
+// ErrInvalidValue is the error of a value that does not parse into its
+// field, such as letters in an integer field.
+var ErrInvalidValue = errors.New("invalid value")
+
+// Decode parses the form of r into dst, a pointer to a struct, then
+// validates it. Validation failures are returned as Errors. The tags of a
+// struct type are checked on its first Decode, which fails when one names
+// an unknown rule or has an invalid parameter.
+func Decode(r *http.Request, dst any) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form: Decode needs a pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	fields, err := fieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+
+	errs := Errors{}
+	for _, f := range fields {
+		values := r.Form[f.name]
+		if !f.secret {
+			values = slices.Clone(values)
+			for j := range values {
+				values[j] = strings.TrimSpace(values[j])
+			}
+		}
+		if err := set(v.Field(f.index), values); err != nil {
+			errs[f.name] = err
+		}
+	}
+
+	for _, f := range fields {
+		if errs[f.name] != nil {
+			continue
+		}
+		if err := validate(v, v.Field(f.index), f.rules); err != nil {
+			errs[f.name] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Redact clears the secret fields of the struct dst points to, so that a
+// form shown again never contains a password.
+func Redact(dst any) {
+	v := reflect.ValueOf(dst).Elem()
+	for i := range v.NumField() {
+		if f, ok := fieldOf(v.Type().Field(i)); ok && f.secret {
+			v.Field(i).SetZero()
+		}
+	}
+}
+
+type field struct {
+	index  int
+	name   string
+	secret bool
+	rules  []rule
+}
+
+type rule struct {
+	name  string
+	param string
+	limit int // parameter of min and max
+}
+
+type parsed struct {
+	fields []field
+	err    error
+}
+
+// cache holds the fields of each struct type decoded so far.
+var cache sync.Map // reflect.Type -> parsed
+
+// fieldsOf returns the form fields of the struct type t, or the error of
+// the first invalid tag.
+func fieldsOf(t reflect.Type) ([]field, error) {
+	if p, ok := cache.Load(t); ok {
+		return p.(parsed).fields, p.(parsed).err
+	}
+	var p parsed
+	for i := range t.NumField() {
+		f, ok := fieldOf(t.Field(i))
+		if !ok {
+			continue
+		}
+		if err := checkField(t, t.Field(i), &f); err != nil {
+			p = parsed{err: fmt.Errorf("form: %s.%s: %w", t, t.Field(i).Name, err)}
+			break
+		}
+		p.fields = append(p.fields, f)
+	}
+	cache.Store(t, p)
+	return p.fields, p.err
+}
+
+func fieldOf(sf reflect.StructField) (field, bool) {
+	tag, ok := sf.Tag.Lookup("form")
+	if !ok || tag == "-" || !sf.IsExported() {
+		return field{}, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	f := field{index: sf.Index[0], name: name, secret: opts == "secret"}
+	if rules := sf.Tag.Get("validate"); rules != "" {
+		for _, r := range strings.Split(rules, ",") {
+			name, param, _ := strings.Cut(r, "=")
+			f.rules = append(f.rules, rule{name: name, param: param})
+		}
+	}
+	return f, true
+}
+
+// checkField makes sure the type of sf is supported and its rules are
+// known and well formed, parsing the limits of min and max.
+func checkField(t reflect.Type, sf reflect.StructField, f *field) error {
+	kind := sf.Type.Kind()
+	switch {
+	case kind == reflect.String, kind == reflect.Bool, isInt(kind), isUint(kind):
+	case kind == reflect.Slice && sf.Type.Elem().Kind() == reflect.String:
+	default:
+		return fmt.Errorf("unsupported field type %s", sf.Type)
+	}
+
+	for i, r := range f.rules {
+		switch r.name {
+		case "required":
+		case "email":
+			if kind != reflect.String {
+				return errors.New("email applies to strings only")
+			}
+		case "min", "max":
+			limit, err := strconv.Atoi(r.param)
+			if err != nil {
+				return fmt.Errorf("invalid %s parameter %q", r.name, r.param)
+			}
+			f.rules[i].limit = limit
+		case "oneof":
+			if len(strings.Fields(r.param)) == 0 {
+				return errors.New("oneof needs at least one option")
+			}
+		case "eqfield":
+			other, ok := t.FieldByName(r.param)
+			if !ok {
+				return fmt.Errorf("eqfield refers to unknown field %q", r.param)
+			}
+			if other.Type != sf.Type {
+				return fmt.Errorf("eqfield refers to field %q of another type", r.param)
+			}
+		default:
+			return fmt.Errorf("unknown validation rule %q", r.name)
+		}
+	}
+	return nil
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uint64
+}
+
+func set(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.ValueOf(slices.DeleteFunc(slices.Clone(values), func(s string) bool { return s == "" })))
+		return nil
+	}
+	if len(values) == 0 || values[0] == "" {
+		v.SetZero()
+		return nil
+	}
+
+	s := values[0]
+	switch kind := v.Kind(); {
+	case kind == reflect.String:
+		v.SetString(s)
+	case kind == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			b = s == "on"
+		}
+		v.SetBool(b)
+	case isInt(kind):
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return ErrInvalidValue
+		}
+		v.SetInt(n)
+	case isUint(kind):
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return ErrInvalidValue
+		}
+		v.SetUint(n)
+	}
+	return nil
+}
+
+func validate(parent, v reflect.Value, rules []rule) error {
+	for _, r := range rules {
+		if !check(parent, v, r) {
+			return &RuleError{Rule: r.name, Param: r.param}
+		}
+	}
+	return nil
+}
+
+func check(parent, v reflect.Value, r rule) bool {
+	switch r.name {
+	case "required":
+		return !v.IsZero() && !(v.Kind() == reflect.Slice && v.Len() == 0)
+	case "email":
+		if v.String() == "" {
+			return true
+		}
+		addr, err := mail.ParseAddress(v.String())
+		return err == nil && addr.Address == v.String()
+	case "min":
+		// An empty optional string is left to the required rule.
+		return (v.Kind() == reflect.String && v.Len() == 0) || size(v) >= r.limit
+	case "max":
+		return size(v) <= r.limit
+	case "oneof":
+		if v.IsZero() {
+			return true
+		}
+		options := strings.Fields(r.param)
+		if v.Kind() == reflect.Slice {
+			for _, s := range v.Interface().([]string) {
+				if !slices.Contains(options, s) {
+					return false
+				}
+			}
+			return true
+		}
+		return slices.Contains(options, fmt.Sprint(v.Interface()))
+	case "eqfield":
+		return v.Equal(parent.FieldByName(r.param))
+	}
+	return false
+}
+
+// size is the length of strings, in characters, and slices, and the value
+// of integers.
+func size(v reflect.Value) int {
+	switch kind := v.Kind(); {
+	case kind == reflect.String:
+		return utf8.RuneCountInString(v.String())
+	case kind == reflect.Slice:
+		return v.Len()
+	case isInt(kind):
+		return int(v.Int())
+	case isUint(kind):
+		return int(v.Uint())
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package form
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func request(values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+type ruleForm struct {
+	Name     string   `form:"name" validate:"required"`
+	Email    string   `form:"email" validate:"email"`
+	Password string   `form:"password,secret" validate:"min=3,max=5"`
+	Confirm  string   `form:"confirm,secret" validate:"eqfield=Password"`
+	Plan     string   `form:"plan" validate:"oneof=free pro"`
+	Tags     []string `form:"tags" validate:"min=1,max=2,oneof=a b c"`
+	Age      int      `form:"age" validate:"min=0,max=120"`
+	Seats    uint     `form:"seats" validate:"min=1"`
+}
+
+func valid() url.Values {
+	return url.Values{
+		"name":     {"Ada"},
+		"email":    {"ada@example.com"},
+		"password": {"abcd"},
+		"confirm":  {"abcd"},
+		"plan":     {"pro"},
+		"tags":     {"a", "b"},
+		"age":      {"36"},
+		"seats":    {"2"},
+	}
+}
+
+func TestDecodeRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		value []string
+		rule  string // failed rule, "" when the form is valid
+	}{
+		{"valid", "", nil, ""},
+		{"required missing", "name", nil, "required"},
+		{"required blank", "name", []string{"   "}, "required"},
+		{"email invalid", "email", []string{"ada"}, "email"},
+		{"email with a name", "email", []string{"Ada <ada@example.com>"}, "email"},
+		{"email optional", "email", nil, ""},
+		{"min string", "password", []string{"ab"}, "min"},
+		{"min counts characters", "password", []string{"ééé"}, ""},
+		{"min optional string", "password", nil, ""},
+		{"max string", "password", []string{"abcdef"}, "max"},
+		{"eqfield mismatch", "confirm", []string{"abce"}, "eqfield"},
+		{"oneof unknown", "plan", []string{"gold"}, "oneof"},
+		{"oneof optional", "plan", nil, ""},
+		{"min slice empty", "tags", nil, "min"},
+		{"max slice", "tags", []string{"a", "b", "c"}, "max"},
+		{"oneof slice", "tags", []string{"a", "d"}, "oneof"},
+		{"min int zero", "age", []string{"0"}, ""},
+		{"min int negative", "age", []string{"-1"}, "min"},
+		{"max int", "age", []string{"121"}, "max"},
+		{"min uint zero", "seats", []string{"0"}, "min"},
+		{"min uint missing", "seats", nil, "min"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := valid()
+			if tt.field != "" {
+				values[tt.field] = tt.value
+			}
+			if tt.field == "password" {
+				values["confirm"] = tt.value
+			}
+			var f ruleForm
+			err := Decode(request(values), &f)
+			if tt.rule == "" {
+				if err != nil {
+					t.Fatalf("Decode() = %v, want nil", err)
+				}
+				return
+			}
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Decode() = %v, want Errors", err)
+			}
+			var rule *RuleError
+			if !errors.As(errs[tt.field], &rule) || rule.Rule != tt.rule {
+				t.Fatalf("errors = %v, want rule %s on %s", errs, tt.rule, tt.field)
+			}
+			if len(errs) != 1 {
+				t.Errorf("errors = %v, want only %s", errs, tt.field)
+			}
+		})
+	}
+}
+
+func TestDecodeValues(t *testing.T) {
+	type values struct {
+		Name     string   `form:"name"`
+		Password string   `form:"password,secret"`
+		Admin    bool     `form:"admin"`
+		Tags     []string `form:"tags"`
+		Count    int8     `form:"count"`
+		Ignored  string   `form:"-"`
+		Untagged string
+	}
+	form := url.Values{
+		"name":     {"  Ada  "},
+		"password": {" secret "},
+		"admin":    {"on"},
+		"tags":     {" a ", "", "b"},
+		"count":    {"12"},
+		"Ignored":  {"x"},
+		"Untagged": {"x"},
+	}
+	r := request(form)
+	var got values
+	if err := Decode(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := values{Name: "Ada", Password: " secret ", Admin: true, Tags: []string{"a", "b"}, Count: 12}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+	if r.Form.Get("name") != "  Ada  " || r.Form["tags"][0] != " a " {
+		t.Errorf("Decode changed the request form: %v", r.Form)
+	}
+
+	Redact(&got)
+	if got.Password != "" || got.Name != "Ada" {
+		t.Errorf("Redact() = %+v, want only the password cleared", got)
+	}
+}
+
+func TestDecodeInvalidValues(t *testing.T) {
+	type numbers struct {
+		Count int8 `form:"count" validate:"required"`
+		Size  uint `form:"size"`
+	}
+	var got numbers
+	err := Decode(request(url.Values{"count": {"300"}, "size": {"-1"}}), &got)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Decode() = %v, want Errors", err)
+	}
+	for _, field := range []string{"count", "size"} {
+		if !errors.Is(errs[field], ErrInvalidValue) {
+			t.Errorf("errors[%s] = %v, want %v", field, errs[field], ErrInvalidValue)
+		}
+	}
+}
+
+type unknownRule struct {
+	Name string `form:"name" validate:"required,uppercase"`
+}
+
+type badLimit struct {
+	Name string `form:"name" validate:"min=three"`
+}
+
+type unknownField struct {
+	Confirm string `form:"confirm" validate:"eqfield=Password"`
+}
+
+type emailOnInt struct {
+	Age int `form:"age" validate:"email"`
+}
+
+type emptyOneof struct {
+	Plan string `form:"plan" validate:"oneof="`
+}
+
+type unsupportedType struct {
+	Price float64 `form:"price"`
+}
+
+func TestDecodeInvalidTags(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  any
+		want string
+	}{
+		{"unknown rule", &unknownRule{}, `unknown validation rule "uppercase"`},
+		{"bad limit", &badLimit{}, `invalid min parameter "three"`},
+		{"eqfield unknown field", &unknownField{}, `unknown field "Password"`},
+		{"email on int", &emailOnInt{}, "email applies to strings only"},
+		{"empty oneof", &emptyOneof{}, "oneof needs at least one option"},
+		{"unsupported type", &unsupportedType{}, "unsupported field type float64"},
+		{"not a pointer", unknownRule{}, "needs a pointer to a struct"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The error is the same once the type is cached.
+			for range 2 {
+				err := Decode(request(url.Values{"name": {"Ada"}}), tt.dst)
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("Decode() = %v, want an error containing %q", err, tt.want)
+				}
+				var errs Errors
+				if errors.As(err, &errs) {
+					t.Fatalf("Decode() = %v, want a programming error, not Errors", err)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,140 @@
 package handler
 
-import "net/http"
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
 
-func Dashboard(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("dashboard"))
+func Dashboard(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+		users, err := service.ListUsers(r.Context(), store, search, r.URL.Query().Get("status"), page)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to list users", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		renderPrivate(w, r, users, "layout.html", "dashboard.html")
+	}
+}
+
+func GetLockouts(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lockouts, err := service.GetLockouts(r.Context(), store)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to list lockouts", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		renderPrivate(w, r, lockouts, "layout.html", "lockouts.html")
+	}
+}
+
+func PostClearLockout(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, subject := r.FormValue("scope"), r.FormValue("subject")
+		if hasEmptyString(w, scope, subject) {
+			return
+		}
+
+		if err := service.ClearLockout(r.Context(), store, scope, subject); err != nil {
+			logger.ErrorContext(r.Context(), "unable to clear lockout", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		http.Redirect(w, r, "/admin/lockouts", http.StatusSeeOther)
+	}
+}
+
+type rolesPage struct {
+	Roles       []*db.Role
+	Permissions []*db.Permission
+	Error       string
+}
+
+func (p rolesPage) Granted(role *db.Role, permission string) bool {
+	for _, name := range role.Permissions {
+		if name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func GetRoles(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderRoles(w, r, store, logger, "")
+	}
+}
+
+func renderRoles(w http.ResponseWriter, r *http.Request, store db.AuthStore, logger *slog.Logger, message string) {
+	roles, permissions, err := service.GetRoles(r.Context(), store)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "unable to list roles", slog.String("error", err.Error()))
+		internal(w)
+		return
+	}
+	renderPrivate(w, r, rolesPage{Roles: roles, Permissions: permissions, Error: message}, "layout.html", "roles.html")
+}
+
+func PostRole(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			badRequest(w)
+			return
+		}
+		name, description := strings.TrimSpace(r.FormValue("name")), strings.TrimSpace(r.FormValue("description"))
+
+		switch err := service.CreateRole(r.Context(), store, name, description, r.Form["permissions"]); err {
+		case nil:
+			http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		case service.ErrInvalidRoleName:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderRoles(w, r, store, logger, "Nom de rôle invalide : 2 à 32 caractères parmi a-z, 0-9, - et _.")
+		default:
+			logger.ErrorContext(r.Context(), "unable to create role", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostRolePermissions(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			badRequest(w)
+			return
+		}
+
+		switch err := service.SetRolePermissions(r.Context(), store, r.PathValue("role"), r.Form["permissions"]); err {
+		case nil:
+			http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		case service.ErrRoleNotEditable:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderRoles(w, r, store, logger, "Le rôle admin dispose toujours de toutes les permissions.")
+		default:
+			logger.ErrorContext(r.Context(), "unable to update role permissions", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostDeleteRole(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch err := service.DeleteRole(r.Context(), store, r.PathValue("role")); err {
+		case nil:
+			http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		case service.ErrRoleNotDeletable:
+			w.WriteHeader(http.StatusConflict)
+			renderRoles(w, r, store, logger, "Les rôles intégrés ou attribués à des utilisateurs ne peuvent pas être supprimés.")
+		default:
+			logger.ErrorContext(r.Context(), "unable to delete role", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
 }
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+func GetUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		detail, err := service.GetUserDetail(r.Context(), store, r.PathValue("id"))
+		switch {
+		case err == nil:
+			renderPrivate(w, r, detail, "layout.html", "user.html")
+		case errors.Is(err, sql.ErrNoRows):
+			http.NotFound(w, r)
+		default:
+			logger.ErrorContext(r.Context(), "unable to get user", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+// userAction adapts a service call on the user in the path to an admin
+// form handler that goes back to the user page. The service layer records
+// the action in the audit log.
+func userAction(name string, logger *slog.Logger, action func(r *http.Request, actor *db.User, id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, id := contextUser(r), r.PathValue("id")
+
+		switch err := action(r, actor, id); err {
+		case nil:
+			if name == "delete" || name == "erase" {
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			http.Redirect(w, r, "/admin/users/"+id, http.StatusSeeOther)
+		case service.ErrCannotModifySelf:
+			http.Error(w, "Impossible sur votre propre compte", http.StatusConflict)
+		case service.ErrPermissionEscalation:
+			http.Error(w, "Ce compte ou ce rôle a des droits que vous n'avez pas", http.StatusForbidden)
+		case service.ErrUnknownRole:
+			unprocessable(w)
+		case service.ErrEmailAlreadyInUse:
+			http.Error(w, "Cette adresse e-mail est utilisée par un autre compte", http.StatusConflict)
+		case service.ErrUserNotDeleted:
+			http.Error(w, "Seuls les comptes supprimés peuvent être effacés", http.StatusConflict)
+		case service.ErrMagicLinkTooSoon:
+			http.Error(w, "Un lien de connexion vient d'être envoyé à ce compte, patientez une minute", http.StatusTooManyRequests)
+		case service.ErrLastOwner:
+			http.Error(w, "Ce compte est le seul propriétaire d'une organisation qui a d'autres membres", http.StatusConflict)
+		case sql.ErrNoRows:
+			http.NotFound(w, r)
+		default:
+			logger.ErrorContext(r.Context(), "unable to perform admin action", slog.String("action", name), slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostUserRole(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("change_role", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.ChangeUserRole(r.Context(), store, actor, id, r.FormValue("role"))
+	})
+}
+
+func PostSuspendUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("suspend", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.SuspendUser(r.Context(), store, actor, id)
+	})
+}
+
+func PostReactivateUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("reactivate", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.ReactivateUser(r.Context(), store, actor, id)
+	})
+}
+
+func PostResetUserPassword(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer, appURL string) http.HandlerFunc {
+	return userAction("force_password_reset", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.ForcePasswordReset(r.Context(), store, actor, id, appURL, mailer)
+	})
+}
+
+func PostRevokeUserSessions(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("revoke_sessions", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.RevokeUserSessions(r.Context(), store, actor, id)
+	})
+}
+
+func PostDeleteUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("delete", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.DeleteUser(r.Context(), store, actor, id)
+	})
+}
+
+func PostRestoreUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("restore", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.RestoreUser(r.Context(), store, actor, id)
+	})
+}
+
+func PostEraseUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return userAction("erase", logger, func(r *http.Request, actor *db.User, id string) error {
+		return service.EraseUser(r.Context(), store, actor, id)
+	})
+}
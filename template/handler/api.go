@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"{{projectName}}/db"
+	"{{projectName}}/form"
+	"{{projectName}}/service"
+)
+
+// Errors raised by the handlers themselves rather than the service layer.
+var (
+	errUnauthenticated   = errors.New("authentication required")
+	errForbidden         = errors.New("forbidden")
+	errInsufficientScope = errors.New("insufficient token scope")
+	errBadRequest        = errors.New("malformed request")
+	errCSRFToken         = errors.New("missing or invalid CSRF token")
+)
+
+// APIError is the body of every JSON error, in an APIErrorResponse
+// envelope: {"error": {...}}. Fields maps form fields to their message for
+// validation errors. Server errors carry the request ID to quote to support.
+type APIError struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type apiErrorStatus struct {
+	status  int
+	code    string
+	message string
+}
+
+// apiErrors maps the known errors to their HTTP status, stable code and
+// user-facing message. Any other error is a 500.
+var apiErrors = map[error]apiErrorStatus{
+	errUnauthenticated:                 {http.StatusUnauthorized, "unauthenticated", "Connectez-vous pour continuer."},
+	errForbidden:                       {http.StatusForbidden, "forbidden", "Vous n'avez pas accès à cette ressource."},
+	errInsufficientScope:               {http.StatusForbidden, "insufficient_scope", "Ce jeton n'a pas l'autorisation nécessaire."},
+	errBadRequest:                      {http.StatusBadRequest, "bad_request", "La requête est mal formée."},
+	errCSRFToken:                       {http.StatusForbidden, "csrf_token_invalid", "Session expirée, rechargez la page et réessayez."},
+	service.ErrInvalidCredentials:      {http.StatusUnauthorized, "invalid_credentials", "Adresse e-mail ou mot de passe incorrect."},
+	service.ErrInvalidEmailFormat:      {http.StatusUnprocessableEntity, "invalid_email", "Entrez une adresse e-mail valide."},
+	service.ErrEmailAlreadyInUse:       {http.StatusConflict, "email_in_use", "Cette adresse e-mail est déjà utilisée."},
+	service.ErrAccountLocked:           {http.StatusTooManyRequests, "account_locked", "Trop de tentatives. Réessayez plus tard."},
+	service.ErrAccountSuspended:        {http.StatusForbidden, "account_suspended", accountSuspendedMessage},
+	service.ErrPasswordResetRequired:   {http.StatusForbidden, "password_reset_required", "Connectez-vous avec un lien envoyé par e-mail pour choisir un nouveau mot de passe."},
+	service.ErrInvalidOTPCode:          {http.StatusUnprocessableEntity, "invalid_otp", "Ce code est incorrect."},
+	service.ErrOTPExpired:              {http.StatusUnprocessableEntity, "otp_expired", "Ce code a expiré, demandez-en un nouveau."},
+	service.ErrOTPTooManyAttempts:      {http.StatusTooManyRequests, "otp_too_many_attempts", "Trop d'essais, demandez un nouveau code."},
+	service.ErrOTPResendTooSoon:        {http.StatusTooManyRequests, "otp_resend_too_soon", "Un code vient d'être envoyé, patientez une minute."},
+	service.ErrInvalidMagicLink:        {http.StatusUnauthorized, "invalid_magic_link", "Ce lien est invalide ou a expiré."},
+	service.ErrInvalidAPIToken:         {http.StatusUnauthorized, "invalid_token", "Ce jeton est invalide ou a expiré."},
+	service.ErrAPITokenNotFound:        {http.StatusNotFound, "not_found", "Ce jeton n'existe pas."},
+	service.ErrImpersonating:           {http.StatusForbidden, "impersonating", "Action indisponible pendant une session d'assistance."},
+	service.ErrOrgForbidden:            {http.StatusForbidden, "org_forbidden", "Votre rôle dans l'organisation ne le permet pas."},
+	service.ErrNotOrgMember:            {http.StatusNotFound, "not_org_member", "Cet utilisateur n'est pas membre de l'organisation."},
+	service.ErrLastOwner:               {http.StatusConflict, "last_owner", "L'organisation doit garder au moins un propriétaire."},
+	service.ErrInvalidOrgRole:          {http.StatusUnprocessableEntity, "invalid_org_role", "Ce rôle n'existe pas."},
+	service.ErrInvalidInvitation:       {http.StatusNotFound, "invalid_invitation", "Cette invitation est invalide ou a expiré."},
+	service.ErrInvitationEmailMismatch: {http.StatusForbidden, "invitation_email_mismatch", "Cette invitation a été envoyée à une autre adresse e-mail."},
+	service.ErrEmailNotVerified:        {http.StatusForbidden, "email_not_verified", "Confirmez d'abord votre adresse e-mail."},
+}
+
+// wantsJSON reports whether to answer r in JSON. Routes under /api/ do,
+// unless the client asks for HTML; other routes do when the client asks for
+// JSON.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(accept, "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// writeAPIError answers err in the JSON error envelope. Unknown errors are
+// logged with msg and hidden behind a generic 500.
+func writeAPIError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, msg string, err error) {
+	var fields service.FieldErrors
+	var formErrs form.Errors
+	if errors.As(err, &formErrs) {
+		fields = service.FieldErrors(formErrs)
+	}
+	if fields != nil || errors.As(err, &fields) {
+		writeJSON(w, http.StatusUnprocessableEntity, APIErrorResponse{APIError{
+			Code:    "validation_failed",
+			Message: "Certains champs sont invalides.",
+			Fields:  fieldMessages(fields),
+		}})
+		return
+	}
+	for known, s := range apiErrors {
+		if errors.Is(err, known) {
+			writeJSON(w, s.status, APIErrorResponse{APIError{Code: s.code, Message: s.message}})
+			return
+		}
+	}
+	id := contextRequestID(r)
+	if logger != nil {
+		logger.ErrorContext(r.Context(), msg, slog.String("error", err.Error()))
+	}
+	writeJSON(w, http.StatusInternalServerError, APIErrorResponse{APIError{
+		Code:      "internal",
+		Message:   "Une erreur est survenue, réessayez plus tard.",
+		RequestID: id,
+	}})
+}
+
+// jsonFormMiddleware lets API clients post JSON objects to the form
+// handlers: the top-level fields of the body become the request's form.
+func jsonFormMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// An empty body, as GET requests have, is an empty object.
+		var body map[string]any
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeAPIError(w, r, nil, "", errBadRequest)
+			return
+		}
+		values := url.Values{}
+		for key, value := range body {
+			v, ok := formValues(value)
+			if !ok {
+				writeAPIError(w, r, nil, "", errBadRequest)
+				return
+			}
+			values[key] = v
+		}
+		for key, v := range r.URL.Query() {
+			values[key] = append(values[key], v...)
+		}
+		r.PostForm, r.Form = values, values
+		next.ServeHTTP(w, r)
+	})
+}
+
+func formValues(value any) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, true
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, true
+	case bool:
+		return []string{strconv.FormatBool(v)}, true
+	case nil:
+		return nil, true
+	case []any:
+		var values []string
+		for _, item := range v {
+			s, ok := formValues(item)
+			if !ok || len(s) != 1 {
+				return nil, false
+			}
+			values = append(values, s...)
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// APIOrganization is an organization the user belongs to, with their role
+// in it.
+type APIOrganization struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Current bool   `json:"current"`
+}
+
+// APIUser is the public representation of a user in the API.
+type APIUser struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Bodies of the API routes, described in the OpenAPI document. Requests
+// may also be sent as forms; the tagged ones are read with form.Decode.
+type (
+	RegisterRequest struct {
+		Email           string `json:"email" form:"email" validate:"required,email,max=254"`
+		Password        string `json:"password" form:"password,secret" validate:"required"`
+		ConfirmPassword string `json:"confirm_password" form:"confirm_password,secret" validate:"required"`
+	}
+	LoginRequest struct {
+		Email    string `json:"email" form:"email" validate:"required,email,max=254"`
+		Password string `json:"password" form:"password,secret" validate:"required"`
+	}
+	MagicLinkRequest struct {
+		Email string `json:"email"`
+	}
+	MagicLinkConfirmRequest struct {
+		Token string `json:"token"`
+	}
+	VerifyOTPRequest struct {
+		Code string `json:"code"`
+	}
+
+	UserResponse struct {
+		User APIUser `json:"user"`
+	}
+	AdminLoginResponse struct {
+		User                 APIUser `json:"user"`
+		VerificationRequired bool    `json:"verification_required"`
+	}
+	MeResponse struct {
+		User   APIUser  `json:"user"`
+		Scopes []string `json:"scopes,omitempty"`
+	}
+	OrganizationsResponse struct {
+		Organizations []APIOrganization `json:"organizations"`
+	}
+)
+
+func newAPIUser(u *db.User) APIUser {
+	return APIUser{ID: u.ID, Email: u.Email, Role: u.Role, Status: u.Status, CreatedAt: u.CreatedAt}
+}
+
+// GetAPIMe describes the signed-in user, and the scopes of the token when
+// called with one.
+func GetAPIMe(w http.ResponseWriter, r *http.Request) {
+	scopes, _ := r.Context().Value(scopesKey).([]string)
+	writeJSON(w, http.StatusOK, MeResponse{newAPIUser(contextUser(r)), scopes})
+}
+
+// GetAPIOrganizations lists the organizations of the signed-in user; the
+// current one is chosen with the X-Organization header.
+func GetAPIOrganizations(w http.ResponseWriter, r *http.Request) {
+	current := contextMembership(r)
+	orgs := []APIOrganization{}
+	for _, m := range contextMemberships(r) {
+		orgs = append(orgs, APIOrganization{
+			ID:      m.OrgID,
+			Name:    m.OrgName,
+			Role:    m.Role,
+			Current: current != nil && current.OrgID == m.OrgID,
+		})
+	}
+	writeJSON(w, http.StatusOK, OrganizationsResponse{orgs})
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// apiTokensPage lists the user's personal access tokens. NewToken is only
+// set right after a token is created, as it cannot be shown again.
+type apiTokensPage struct {
+	Tokens   []*db.APIToken
+	NewToken string
+	Name     string
+	Scopes   []string
+	Errors   map[string]string
+}
+
+func (apiTokensPage) AllScopes() []string { return service.APIScopes }
+func (apiTokensPage) Lifetimes() []int    { return service.APITokenLifetimes }
+
+func renderAPITokens(w http.ResponseWriter, r *http.Request, store db.AuthStore, logger *slog.Logger, page apiTokensPage) {
+	tokens, err := store.GetUserAPITokens(r.Context(), contextUser(r).ID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "unable to list api tokens", slog.String("error", err.Error()))
+		internal(w)
+		return
+	}
+	page.Tokens = tokens
+	renderPrivate(w, r, page, "app-layout.html", "app-tokens.html")
+}
+
+func GetAPITokens(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderAPITokens(w, r, store, logger, apiTokensPage{})
+	}
+}
+
+func PostAPIToken(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			badRequest(w)
+			return
+		}
+		name, scopes := r.PostForm.Get("name"), r.PostForm["scopes"]
+		days, _ := strconv.Atoi(r.PostForm.Get("expires"))
+
+		token, _, err := service.CreateAPIToken(r.Context(), store, contextUser(r), name, scopes, days)
+		var fields service.FieldErrors
+		switch {
+		case err == nil:
+			renderAPITokens(w, r, store, logger, apiTokensPage{NewToken: token})
+		case errors.As(err, &fields):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderAPITokens(w, r, store, logger, apiTokensPage{Name: name, Scopes: scopes, Errors: fieldMessages(fields)})
+		default:
+			logger.ErrorContext(r.Context(), "unable to create api token", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostRevokeAPIToken(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch err := service.RevokeAPIToken(r.Context(), store, contextUser(r), r.PathValue("id")); {
+		case err == nil:
+			addFlash(r, flashSuccess, "Le jeton a été supprimé.")
+			http.Redirect(w, r, "/app/jetons", http.StatusSeeOther)
+		case errors.Is(err, service.ErrAPITokenNotFound):
+			http.NotFound(w, r)
+		default:
+			logger.ErrorContext(r.Context(), "unable to revoke api token", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
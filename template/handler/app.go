@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// accountPage is the data of the user area's account page. Errors are keyed
+// by form field. Successful changes redirect back to the page with a flash.
+type accountPage struct {
+	User     *db.User
+	NewEmail string
+	Errors   map[string]string
+}
+
+// GraceDays is the delay before a requested erasure happens.
+func (accountPage) GraceDays() int { return int(service.ErasureGracePeriod.Hours() / 24) }
+
+func renderAccount(w http.ResponseWriter, r *http.Request, page accountPage) {
+	page.User = contextUser(r)
+	renderPrivate(w, r, page, "app-layout.html", "app-account.html")
+}
+
+// accountError renders the account page with the field errors of err, or
+// answers with the matching status when err is not a validation error.
+func accountError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, page accountPage, err error) {
+	var fields service.FieldErrors
+	switch {
+	case errors.As(err, &fields):
+		page.Errors = fieldMessages(fields)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderAccount(w, r, page)
+	case errors.Is(err, service.ErrAccountLocked):
+		tooManyRequests(w)
+	case errors.Is(err, service.ErrLastOwner):
+		page.Errors = map[string]string{"delete": "Nommez un autre propriétaire de vos organisations avant de supprimer votre compte."}
+		w.WriteHeader(http.StatusConflict)
+		renderAccount(w, r, page)
+	default:
+		logger.ErrorContext(r.Context(), "unable to update account", slog.String("error", err.Error()))
+		internal(w)
+	}
+}
+
+func GetAccount(w http.ResponseWriter, r *http.Request) {
+	renderAccount(w, r, accountPage{})
+}
+
+func PostChangePassword(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+
+		err = service.ChangePassword(r.Context(), store, contextUser(r),
+			r.FormValue("current_password"), r.FormValue("password"), r.FormValue("confirm_password"), cookie.Value)
+		if err != nil {
+			accountError(w, r, logger, accountPage{}, err)
+			return
+		}
+		addFlash(r, flashSuccess, "Votre mot de passe a été modifié. Vos autres sessions ont été fermées.")
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
+	}
+}
+
+func PostChangeEmail(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := strings.TrimSpace(r.FormValue("email"))
+		tolowerall(&email)
+		page := accountPage{NewEmail: email}
+
+		err := service.RequestEmailChange(r.Context(), store, contextUser(r), email, r.FormValue("current_password"), appURL, mailer)
+		if err != nil {
+			accountError(w, r, logger, page, err)
+			return
+		}
+		addFlash(r, flashInfo, "Un lien de confirmation a été envoyé à "+email+".")
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
+	}
+}
+
+// PostDeleteAccount schedules the erasure of the account; it can be
+// cancelled from the account page until the grace period is over.
+func PostDeleteAccount(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+
+		_, err = service.ScheduleErasure(r.Context(), store, contextUser(r),
+			r.FormValue("password"), r.FormValue("confirm_email"), cookie.Value, mailer)
+		if err != nil {
+			accountError(w, r, logger, accountPage{}, err)
+			return
+		}
+		addFlash(r, flashInfo, "La suppression de votre compte est programmée.")
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
+	}
+}
+
+func PostCancelErasure(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := contextUser(r)
+		if err := service.CancelErasure(r.Context(), store, u); err != nil {
+			logger.ErrorContext(r.Context(), "unable to cancel erasure", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		addFlash(r, flashSuccess, "La suppression de votre compte est annulée.")
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
+	}
+}
+
+// GetDataExport downloads a ZIP archive of the user's personal data.
+func GetDataExport(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := service.ExportUserData(r.Context(), store, contextUser(r), &buf); err != nil {
+			logger.ErrorContext(r.Context(), "unable to export user data", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="mes-donnees-`+time.Now().Format("2006-01-02")+`.zip"`)
+		_, _ = buf.WriteTo(w)
+	}
+}
+
+// PostLogout ends the session. Logging out while impersonating a user also
+// ends the admin's own session kept aside, rather than leave it in the
+// browser.
+func PostLogout(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"session", adminSessionCookie} {
+			if cookie, err := r.Cookie(name); err == nil {
+				if err := service.RevokeSession(r.Context(), cookie.Value, store); err != nil {
+					logger.ErrorContext(r.Context(), "unable to revoke session", slog.String("error", err.Error()))
+				}
+			}
+		}
+		clearSessionCookie(w)
+		clearAdminSessionCookie(w)
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusNoContent, nil)
+			return
+		}
+		addFlash(r, flashInfo, "Vous êtes déconnecté.")
+		http.Redirect(w, r, "/connexion", http.StatusSeeOther)
+	}
+}
+
+type emailConfirmPage struct {
+	Token string
+	Email string
+	Error string
+}
+
+// GetEmailConfirm only renders a confirmation button, like the login link.
+func GetEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	renderPublic(w, r, emailConfirmPage{Token: r.URL.Query().Get("token")}, "layout.html", "email-confirm.html")
+}
+
+func PostEmailConfirm(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := service.ConfirmEmailChange(r.Context(), store, r.FormValue("token"), mailer)
+		switch {
+		case err == nil:
+			renderPublic(w, r, emailConfirmPage{Email: u.Email}, "layout.html", "email-confirm.html")
+		case errors.Is(err, service.ErrInvalidEmailChange):
+			w.WriteHeader(http.StatusUnauthorized)
+			renderPublic(w, r, emailConfirmPage{Error: "Ce lien est invalide ou a expiré."}, "layout.html", "email-confirm.html")
+		case errors.Is(err, service.ErrEmailAlreadyInUse):
+			w.WriteHeader(http.StatusConflict)
+			renderPublic(w, r, emailConfirmPage{Error: fieldErrorMessages[err]}, "layout.html", "email-confirm.html")
+		default:
+			logger.ErrorContext(r.Context(), "unable to confirm email change", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
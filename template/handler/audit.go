@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+const (
+	auditDateLayout  = "2006-01-02"
+	auditExportLimit = 10000
+)
+
+// auditPage keeps the raw filter values so the form and the pagination
+// links can be rendered back.
+type auditPage struct {
+	service.AuditPage
+	From string
+	To   string
+}
+
+func (p auditPage) query() url.Values {
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"action": p.Filter.Action,
+		"actor":  p.Filter.Actor,
+		"target": p.Filter.Target,
+		"from":   p.From,
+		"to":     p.To,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	return q
+}
+
+func (p auditPage) PageURL(page int) string {
+	q := p.query()
+	q.Set("page", strconv.Itoa(page))
+	return "/admin/audit?" + q.Encode()
+}
+
+func (p auditPage) ExportURL() string {
+	return "/admin/audit/export?" + p.query().Encode()
+}
+
+// parseAuditFilter reads the filter form. Dates are whole days, "to"
+// included.
+func parseAuditFilter(r *http.Request) (db.AuditFilter, auditPage, bool) {
+	q := r.URL.Query()
+	page := auditPage{From: q.Get("from"), To: q.Get("to")}
+	f := db.AuditFilter{
+		Action: strings.TrimSpace(q.Get("action")),
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Target: strings.TrimSpace(q.Get("target")),
+	}
+
+	if page.From != "" {
+		from, err := time.Parse(auditDateLayout, page.From)
+		if err != nil {
+			return f, page, false
+		}
+		f.From = &from
+	}
+	if page.To != "" {
+		to, err := time.Parse(auditDateLayout, page.To)
+		if err != nil {
+			return f, page, false
+		}
+		to = to.AddDate(0, 0, 1)
+		f.To = &to
+	}
+	page.Filter = f
+	return f, page, true
+}
+
+func GetAudit(store db.AuditStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, page, ok := parseAuditFilter(r)
+		if !ok {
+			badRequest(w)
+			return
+		}
+		n, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+		events, err := service.ListAuditEvents(r.Context(), store, f, n)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to list audit events", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		page.AuditPage = events
+		renderPrivate(w, r, page, "layout.html", "audit.html")
+	}
+}
+
+func GetAuditExport(store db.AuditStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, _, ok := parseAuditFilter(r)
+		if !ok {
+			badRequest(w)
+			return
+		}
+
+		events, err := service.ExportAuditEvents(r.Context(), store, f, auditExportLimit)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to export audit events", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format(auditDateLayout)+`.csv"`)
+
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"date", "action", "actor_id", "actor_email", "target", "ip_address", "user_agent", "metadata"})
+		for _, e := range events {
+			metadata, _ := json.Marshal(e.Metadata)
+			_ = cw.Write([]string{
+				e.CreatedAt.Format(time.RFC3339),
+				e.Action,
+				e.ActorID,
+				e.ActorEmail,
+				e.Target,
+				e.IPAddress,
+				e.UserAgent,
+				string(metadata),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			logger.ErrorContext(r.Context(), "unable to write audit export", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -1,38 +1,86 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
 	"net/http"
 	"time"
 	"{{projectName}}/db"
+	"{{projectName}}/form"
+	"{{projectName}}/metrics"
 	"{{projectName}}/service"
+	"{{projectName}}/tracing"
 
 	"golang.org/x/oauth2"
 )
 
 func Home(w http.ResponseWriter, r *http.Request) {
-	renderPublic(w, nil, "layout.html", "home.html")
+	renderPublic(w, r, nil, "layout.html", "home.html")
 }
 
 func GetRegister(w http.ResponseWriter, r *http.Request) {
-	renderPublic(w, nil, "layout.html", "register.html")
+	renderPublic(w, r, nil, "layout.html", "register.html")
 }
 
 func GetLogin(w http.ResponseWriter, r *http.Request) {
-	renderPublic(w, nil, "layout.html", "login.html")
+	renderPublic(w, r, nil, "layout.html", "login.html")
+}
+
+// registerPage and loginPage show a submitted form again with the messages
+// of its invalid fields, and for the login a message about the whole form.
+// The passwords are redacted before rendering.
+type registerPage struct {
+	RegisterRequest
+	Errors map[string]string
+}
+
+type loginPage struct {
+	LoginRequest
+	Errors map[string]string
+	Error  string
 }
 
 func RegisterUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		email, password, confirmPassword := r.FormValue("email"), r.FormValue("password"), r.FormValue("confirm_password")
-		tolowerall(&email)
+		var page registerPage
+		rerender := func(status int, fields service.FieldErrors) {
+			form.Redact(&page.RegisterRequest)
+			page.Errors = fieldMessages(fields)
+			w.WriteHeader(status)
+			renderPublic(w, r, page, "layout.html", "register.html")
+		}
+
+		err := form.Decode(r, &page.RegisterRequest)
+		tolowerall(&page.Email)
+		var formErrs form.Errors
+		switch {
+		case errors.As(err, &formErrs):
+			if wantsJSON(r) {
+				writeAPIError(w, r, logger, "", err)
+				return
+			}
+			rerender(http.StatusUnprocessableEntity, service.FieldErrors(formErrs))
+			return
+		case err != nil:
+			if wantsJSON(r) {
+				writeAPIError(w, r, logger, "", errBadRequest)
+				return
+			}
+			badRequest(w)
+			return
+		}
+		email, password := page.Email, page.Password
 
-		if password != confirmPassword {
-			http.Error(w, "Passwords do not match", http.StatusBadRequest)
+		if fields := service.ValidateNewPassword(email, password, page.ConfirmPassword); len(fields) > 0 {
+			if wantsJSON(r) {
+				writeAPIError(w, r, logger, "", fields)
+				return
+			}
+			rerender(http.StatusUnprocessableEntity, fields)
 			return
 		}
 
@@ -41,13 +89,18 @@ func RegisterUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 			PasswordHash: password,
 		})
 		if err != nil {
-			switch err {
-			case service.ErrInvalidEmailFormat, service.ErrPasswordTooWeak:
-				w.WriteHeader(http.StatusBadRequest)
-			case service.ErrEmailAlreadyInUse:
-				w.WriteHeader(http.StatusConflict)
+			if wantsJSON(r) {
+				writeAPIError(w, r, logger, "unable to create user", err)
+				return
+			}
+			var fields service.FieldErrors
+			switch {
+			case errors.As(err, &fields):
+				rerender(http.StatusUnprocessableEntity, fields)
+			case errors.Is(err, service.ErrEmailAlreadyInUse):
+				rerender(http.StatusConflict, service.FieldErrors{"email": err})
 			default:
-				logger.Error("unable to create user", slog.String("error", err.Error()))
+				logger.ErrorContext(r.Context(), "unable to create user", slog.String("error", err.Error()))
 				internal(w)
 			}
 			return
@@ -55,7 +108,11 @@ func RegisterUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 
 		cookieHash, err := service.CreateSession(ctx, store, created.ID, r)
 		if err != nil {
-			logger.Error("unable to create session", slog.String("error", err.Error()))
+			if wantsJSON(r) {
+				writeAPIError(w, r, logger, "unable to create session", err)
+				return
+			}
+			logger.ErrorContext(r.Context(), "unable to create session", slog.String("error", err.Error()))
 			internal(w)
 			return
 		}
@@ -69,6 +126,10 @@ func RegisterUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 			MaxAge:   int(24 * time.Hour.Seconds()),
 		})
 
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusCreated, UserResponse{newAPIUser(created)})
+			return
+		}
 		http.Redirect(w, r, "/app", http.StatusSeeOther)
 	}
 }
@@ -76,32 +137,64 @@ func RegisterUser(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 func PostLogin(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		email, password := r.FormValue("email"), r.FormValue("password")
-		tolowerall(&email)
+		var page loginPage
+		err := form.Decode(r, &page.LoginRequest)
+		tolowerall(&page.Email)
+		var formErrs form.Errors
+		if err != nil && !errors.As(err, &formErrs) {
+			err = errBadRequest
+		}
 
-		u, err := service.LoginUser(ctx, store, db.User{Email: email, PasswordHash: password})
-		switch err {
-		case nil:
-			cookieHash, err := service.CreateSession(ctx, store, u.ID, r)
+		var u *db.User
+		if err == nil {
+			u, err = service.LoginUser(ctx, store, db.User{Email: page.Email, PasswordHash: page.Password})
+			if errors.Is(err, sql.ErrNoRows) {
+				err = service.ErrInvalidCredentials
+			}
+		}
+		if err == nil {
+			var cookieHash string
+			if cookieHash, err = service.CreateSession(ctx, store, u.ID, r); err == nil {
+				setSessionCookie(w, cookieHash)
+			}
+		}
+		if wantsJSON(r) {
 			if err != nil {
-				internal(w)
+				writeAPIError(w, r, logger, "unable to log in", err)
+				return
 			}
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session",
-				Value:    cookieHash,
-				Path:     "/",
-				HttpOnly: true,
-				SameSite: http.SameSiteStrictMode,
-				MaxAge:   int(24 * time.Hour.Seconds()),
-			})
-		case service.ErrInvalidEmailFormat, service.ErrPasswordTooWeak:
-			unprocessable(w)
-		case sql.ErrNoRows, service.ErrInvalidCredentials:
-			unauthorized(w)
+			writeJSON(w, http.StatusOK, UserResponse{newAPIUser(u)})
+			return
+		}
+
+		status := http.StatusUnprocessableEntity
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "/app", http.StatusSeeOther)
+			return
+		case errors.Is(err, service.ErrPasswordResetRequired):
+			http.Redirect(w, r, "/connexion/lien", http.StatusSeeOther)
+			return
+		case errors.Is(err, errBadRequest):
+			badRequest(w)
+			return
+		case formErrs != nil:
+			page.Errors = fieldMessages(service.FieldErrors(formErrs))
+		case errors.Is(err, service.ErrInvalidEmailFormat):
+			page.Errors = fieldMessages(service.FieldErrors{"email": err})
+		case errors.Is(err, service.ErrInvalidCredentials),
+			errors.Is(err, service.ErrAccountLocked),
+			errors.Is(err, service.ErrAccountSuspended):
+			s := apiErrors[err]
+			status, page.Error = s.status, s.message
 		default:
-			logger.Error("unable to create user", slog.String("error", err.Error()))
+			logger.ErrorContext(r.Context(), "unable to log in", slog.String("error", err.Error()))
 			internal(w)
+			return
 		}
+		form.Redact(&page.LoginRequest)
+		w.WriteHeader(status)
+		renderPublic(w, r, page, "layout.html", "login.html")
 	}
 }
 
@@ -124,13 +217,16 @@ func HandleGoogleLogin(oauth *oauth2.Config) http.HandlerFunc {
 
 func HandleGoogleCallback(store db.Store, oauth *oauth2.Config, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		// The token exchange and the user info request go through the
+		// traced client.
+		ctx := context.WithValue(r.Context(), oauth2.HTTPClient, tracing.HTTPClient)
 
 		var err error
 
 		stateCookie, err := r.Cookie("oauth_state")
 		if err != nil || r.URL.Query().Get("state") != stateCookie.Value {
-			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
+			metrics.Logins.WithLabelValues("google", "failure").Inc()
+			http.Error(w, "La connexion avec Google a expiré, réessayez", http.StatusBadRequest)
 			return
 		}
 
@@ -147,15 +243,20 @@ func HandleGoogleCallback(store db.Store, oauth *oauth2.Config, logger *slog.Log
 		code := r.URL.Query().Get("code")
 		token, err := oauth.Exchange(ctx, code)
 		if err != nil {
-			logger.Error("unable to Exchange code", slog.String("error", err.Error()))
+			logger.ErrorContext(r.Context(), "unable to Exchange code", slog.String("error", err.Error()))
 			internal(w)
 			return
 		}
 
 		client := oauth.Client(ctx, token)
-		resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.googleapis.com/oauth2/v2/userinfo", nil)
 		if err != nil {
-			logger.Error("unable to get the client responses", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to get the client responses", slog.String("error", err.Error()))
 			internal(w)
 			return
 		}
@@ -174,15 +275,39 @@ func HandleGoogleCallback(store db.Store, oauth *oauth2.Config, logger *slog.Log
 			return
 		}
 		if !userInfo.VerifiedEmail {
-			http.Error(w, "Email not verified by Google", http.StatusUnauthorized)
+			metrics.Logins.WithLabelValues("google", "failure").Inc()
+			http.Error(w, "Votre adresse e-mail n'est pas vérifiée par Google", http.StatusUnauthorized)
 			return
 		}
 		var user *db.User
 		user, err = store.GetUserByEmail(ctx, userInfo.Email)
 		switch err {
 		case nil:
-			// User found, continue
+			if user.Status != db.UserStatusActive {
+				metrics.Logins.WithLabelValues("google", "failure").Inc()
+				http.Error(w, accountSuspendedMessage, http.StatusForbidden)
+				return
+			}
+			// Google vouches for the address.
+			if !user.EmailVerified {
+				if err := store.MarkEmailVerified(ctx, user.ID); err != nil {
+					logger.ErrorContext(r.Context(), "unable to mark email verified", slog.String("error", err.Error()))
+					internal(w)
+					return
+				}
+			}
 		case sql.ErrNoRows:
+			// The address of a soft-deleted account is kept for it until
+			// it is erased: only an admin can bring it back.
+			if _, err := store.GetDeletedUserByEmail(ctx, userInfo.Email); err == nil {
+				metrics.Logins.WithLabelValues("google", "failure").Inc()
+				http.Error(w, accountDeletedMessage, http.StatusForbidden)
+				return
+			} else if err != sql.ErrNoRows {
+				logger.ErrorContext(r.Context(), "unable to look for a deleted account", slog.String("error", err.Error()))
+				internal(w)
+				return
+			}
 			newUser := &db.User{
 				Email:    userInfo.Email,
 				GoogleID: userInfo.ID,
@@ -190,26 +315,19 @@ func HandleGoogleCallback(store db.Store, oauth *oauth2.Config, logger *slog.Log
 			}
 			user, err = store.CreateUserWithGoogle(ctx, newUser)
 			if err != nil {
-				logger.Error("unable to create user with google", slog.String("error", err.Error()))
+				logger.ErrorContext(r.Context(), "unable to create user with google", slog.String("error", err.Error()))
 				internal(w)
 				return
 			}
 		default:
 			internal(w)
-			logger.Error("before session", slog.String("error", err.Error()))
+			logger.ErrorContext(r.Context(), "before session", slog.String("error", err.Error()))
 			return
 		}
 
 		sessionToken, err := service.CreateSession(ctx, store, user.ID, r)
 		if err != nil {
-			logger.Error("unable to create session", slog.String("error", err.Error()))
-			internal(w)
-			return
-		}
-
-		csrfToken, err := service.GenerateSessionToken()
-		if err != nil {
-			logger.Error("unable to genereate session", slog.String("error", err.Error()))
+			logger.ErrorContext(r.Context(), "unable to create session", slog.String("error", err.Error()))
 			internal(w)
 			return
 		}
@@ -223,33 +341,40 @@ func HandleGoogleCallback(store db.Store, oauth *oauth2.Config, logger *slog.Log
 			MaxAge:   int(24 * time.Hour.Seconds()),
 		})
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "csrf_token",
-			Value:    csrfToken,
-			Path:     "/",
-			HttpOnly: false,
-			SameSite: http.SameSiteStrictMode,
-			MaxAge:   int(24 * time.Hour.Seconds()),
-		})
+		metrics.Logins.WithLabelValues("google", "success").Inc()
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
 	}
 }
 
 func GetAdminLogin(w http.ResponseWriter, r *http.Request) {
-	renderPublic(w, nil, "layout.html", "admin-login.html")
+	renderPublic(w, r, nil, "layout.html", "admin-login.html")
 }
 
-func PostAdminLogin(store db.AuthStore, logger *slog.Logger, mail string) http.HandlerFunc {
+func PostAdminLogin(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		email, password := r.FormValue("email"), r.FormValue("password")
 		tolowerall(&email)
 
 		u, err := service.LoginUser(ctx, store, db.User{Email: email, PasswordHash: password})
+		if errors.Is(err, sql.ErrNoRows) {
+			err = service.ErrInvalidCredentials
+		}
+		if err != nil && wantsJSON(r) {
+			writeAPIError(w, r, logger, "unable to log in", err)
+			return
+		}
 		switch err {
 		case nil:
 			cookieHash, err2 := service.CreateSession(ctx, store, u.ID, r)
 			if err2 != nil {
+				if wantsJSON(r) {
+					writeAPIError(w, r, logger, "unable to create session", err2)
+					return
+				}
+				logger.ErrorContext(r.Context(), "unable to create session", slog.String("error", err2.Error()))
 				internal(w)
+				return
 			}
 			http.SetCookie(w, &http.Cookie{
 				Name:     "session",
@@ -260,19 +385,37 @@ func PostAdminLogin(store db.AuthStore, logger *slog.Logger, mail string) http.H
 				MaxAge:   int(24 * time.Hour.Seconds()),
 			})
 
-			if err = service.CreateOTP(r.Context(), store, u.ID, mail); err != nil {
-				logger.Debug("unable to created or send otp", slog.String("error", err.Error()))
+			// A code delivered less than a minute ago is still valid, reuse it.
+			err = service.CreateOTP(r.Context(), store, u.ID, u.Email, mailer)
+			if err != nil && err != service.ErrOTPResendTooSoon {
+				if wantsJSON(r) {
+					writeAPIError(w, r, logger, "unable to create or send otp", err)
+					return
+				}
+				logger.ErrorContext(r.Context(), "unable to create or send otp", slog.String("error", err.Error()))
+				internal(w)
 				return
 			}
 
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusAccepted, AdminLoginResponse{newAPIUser(u), true})
+				return
+			}
+			addFlash(r, flashInfo, "Un code de vérification a été envoyé à votre adresse e-mail.")
 			http.Redirect(w, r, "/admin/verify", http.StatusSeeOther)
 
-		case service.ErrInvalidEmailFormat, service.ErrPasswordTooWeak:
+		case service.ErrInvalidEmailFormat:
 			unprocessable(w)
-		case sql.ErrNoRows, service.ErrInvalidCredentials:
+		case service.ErrInvalidCredentials:
 			unauthorized(w)
+		case service.ErrAccountLocked:
+			tooManyRequests(w)
+		case service.ErrAccountSuspended:
+			http.Error(w, accountSuspendedMessage, http.StatusForbidden)
+		case service.ErrPasswordResetRequired:
+			http.Redirect(w, r, "/connexion/lien", http.StatusSeeOther)
 		default:
-			logger.Error("unable to create user", slog.String("error", err.Error()))
+			logger.ErrorContext(r.Context(), "unable to create user", slog.String("error", err.Error()))
 			internal(w)
 		}
 	}
@@ -282,38 +425,72 @@ func GetVerifyOTP(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		u := contextUser(r)
 		if err := store.UpdateVerify(r.Context(), u.ID, false); err != nil {
-			fmt.Printf("err.Error(): %v\n", err.Error())
+			logger.ErrorContext(r.Context(), "unable to reset otp verification", slog.String("error", err.Error()))
 			internal(w)
 			return
 		}
-		renderPrivate(w, nil, "layout.html", "otp.html")
+		renderPrivate(w, r, nil, "layout.html", "otp.html")
 	})
 }
 
-func PostVerifyOTP(store db.AuthStore) http.HandlerFunc {
+func PostVerifyOTP(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c := r.FormValue("code")
-		n := len(c)
-		if c == "" || n != 6 {
-			unprocessable(w)
+		u := contextUser(r)
+
+		var err error
+		if len(c) != 6 {
+			err = service.ErrInvalidOTPCode
+		} else if err = service.ValidateOTP(r.Context(), u.ID, c, store); err == nil {
+			err = store.UpdateVerify(r.Context(), u.ID, true)
+		}
+		if wantsJSON(r) {
+			if err != nil {
+				writeAPIError(w, r, logger, "unable to verify otp", err)
+				return
+			}
+			writeJSON(w, http.StatusNoContent, nil)
 			return
 		}
-		u := contextUser(r)
 
-		err := service.ValidateOTP(r.Context(), u.ID, c, store)
 		switch err {
 		case nil:
-			if err := store.UpdateVerify(r.Context(), u.ID, true); err != nil {
-				internal(w)
-				return
-			}
 			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
 		case service.ErrInvalidOTPCode:
 			unprocessable(w)
+		case service.ErrAccountLocked:
+			tooManyRequests(w)
 		case service.ErrOTPExpired:
-			w.Write([]byte("expired code"))
+			http.Error(w, "Ce code a expiré, demandez-en un nouveau", http.StatusUnprocessableEntity)
+		case service.ErrOTPTooManyAttempts:
+			http.Error(w, "Trop d'essais, demandez un nouveau code", http.StatusTooManyRequests)
 		default:
 			internal(w)
 		}
 	})
 }
+
+func PostResendOTP(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := contextUser(r)
+		err := service.CreateOTP(r.Context(), store, u.ID, u.Email, mailer)
+		if wantsJSON(r) {
+			if err != nil {
+				writeAPIError(w, r, logger, "unable to resend otp", err)
+				return
+			}
+			writeJSON(w, http.StatusNoContent, nil)
+			return
+		}
+		switch err {
+		case nil:
+			addFlash(r, flashSuccess, "Un nouveau code vous a été envoyé.")
+			http.Redirect(w, r, "/admin/verify", http.StatusSeeOther)
+		case service.ErrOTPResendTooSoon:
+			tooManyRequests(w)
+		default:
+			logger.ErrorContext(r.Context(), "unable to resend otp", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// errorPage is the data of the error template. Message replaces the
+// default explanation of the status when the handler gave one.
+type errorPage struct {
+	Status    int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+type errorText struct {
+	code, title, message string
+}
+
+// errorTexts are the titles and messages of the error pages, and the code
+// of the matching JSON errors.
+var errorTexts = map[int]errorText{
+	http.StatusBadRequest:          {"bad_request", "Requête invalide", "La requête est mal formée."},
+	http.StatusUnauthorized:        {"unauthenticated", "Connexion requise", "Connectez-vous pour continuer."},
+	http.StatusForbidden:           {"forbidden", "Accès refusé", "Vous n'avez pas accès à cette page."},
+	http.StatusNotFound:            {"not_found", "Page introuvable", "Cette page n'existe pas ou a été déplacée."},
+	http.StatusMethodNotAllowed:    {"method_not_allowed", "Action impossible", "Cette page ne permet pas cette action."},
+	http.StatusConflict:            {"conflict", "Action impossible", "Cette action n'est pas possible dans l'état actuel."},
+	http.StatusUnprocessableEntity: {"unprocessable", "Données invalides", "Certaines informations envoyées sont invalides."},
+	http.StatusTooManyRequests:     {"too_many_requests", "Trop de requêtes", "Trop de tentatives. Réessayez dans quelques instants."},
+	http.StatusInternalServerError: {"internal", "Erreur interne", "Une erreur est survenue, réessayez plus tard."},
+}
+
+func errorTextOf(status int) errorText {
+	if text, ok := errorTexts[status]; ok {
+		return text
+	}
+	if status >= 500 {
+		return errorTexts[http.StatusInternalServerError]
+	}
+	return errorText{"error", http.StatusText(status), ""}
+}
+
+// writeError answers an error status with the error page, or the JSON
+// error envelope for API clients. Server errors are logged with the
+// request ID shown to the user.
+func writeError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, status int, message string) {
+	text := errorTextOf(status)
+	if message == "" {
+		message = text.message
+	}
+	id := contextRequestID(r)
+	if status >= 500 {
+		logger.ErrorContext(r.Context(), "internal error",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status))
+	}
+
+	w.Header().Del("Content-Length")
+	if wantsJSON(r) {
+		apiErr := APIError{Code: text.code, Message: message}
+		if status >= 500 {
+			apiErr.RequestID = id
+		}
+		writeJSON(w, status, APIErrorResponse{apiErr})
+		return
+	}
+
+	page := errorPage{Status: status, Title: text.title, Message: message, RequestID: id}
+	buf, err := pages.execute(w, r, publicTree, "layout.html", "error.html", page)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "unable to render error page", slog.String("error", err.Error()))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(text.title + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+// errorPagesMiddleware is the single place errors are shown. Handlers only
+// set an error status, possibly with a plain text message as http.Error
+// does; the middleware replaces the response with the error page or JSON
+// error. Responses the handler rendered itself, such as a form shown again
+// with its errors, are left alone.
+func errorPagesMiddleware(logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ew := &errorResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, r)
+			if ew.status != 0 && !ew.decided {
+				ew.decide()
+			}
+			if !ew.intercepted {
+				return
+			}
+
+			message := strings.TrimSpace(ew.body.String())
+			// http.NotFound and the ServeMux say nothing useful.
+			if message == "404 page not found" || message == http.StatusText(ew.status) {
+				message = ""
+			}
+			writeError(w, r, logger, ew.status, message)
+		})
+	}
+}
+
+// errorResponseWriter holds back the status until the first write, when
+// the content type tells whether the handler rendered a page of its own or
+// only an error for errorPagesMiddleware to show.
+type errorResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	decided     bool
+	intercepted bool
+	body        bytes.Buffer
+}
+
+func (ew *errorResponseWriter) WriteHeader(status int) {
+	if ew.status != 0 {
+		return
+	}
+	ew.status = status
+	if status < 400 {
+		ew.decide()
+	}
+}
+
+func (ew *errorResponseWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if !ew.decided {
+		ew.decide()
+	}
+	if ew.intercepted {
+		if ew.body.Len() < 1024 {
+			ew.body.Write(b)
+		}
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *errorResponseWriter) decide() {
+	ew.decided = true
+	contentType := ew.Header().Get("Content-Type")
+	if ew.status >= 400 && (contentType == "" || strings.HasPrefix(contentType, "text/plain")) {
+		ew.intercepted = true
+		return
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+}
+
+func (ew *errorResponseWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"{{projectName}}/service"
+)
+
+const flashCookie = "flash"
+
+// flashLevel is the kind of a flash message, named after the alert style it
+// is shown with.
+type flashLevel string
+
+const (
+	flashSuccess flashLevel = "success"
+	flashInfo    flashLevel = "info"
+	flashError   flashLevel = "error"
+)
+
+// flash is a message shown once, on the page following a redirect.
+type flash struct {
+	Level   flashLevel `json:"level"`
+	Message string     `json:"message"`
+}
+
+type flashKey struct{}
+
+// flashes are the messages of the request: those received in the cookie,
+// until a page shows them, and those queued for the next page.
+type flashes struct {
+	received []flash
+	queued   []flash
+	shown    bool
+}
+
+// flashMiddleware writes the flash cookie once, with the response headers,
+// from the messages the handler queued or showed.
+func flashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := &flashes{}
+		if cookie, err := r.Cookie(flashCookie); err == nil {
+			f.received = decodeFlashes(cookie.Value)
+		}
+		fw := &flashResponseWriter{ResponseWriter: w, flashes: f}
+		next.ServeHTTP(fw, r.WithContext(context.WithValue(r.Context(), flashKey{}, f)))
+		fw.setCookie()
+	})
+}
+
+// addFlash queues a message for the next page rendered for the user, which
+// is usually the target of the redirect that follows.
+func addFlash(r *http.Request, level flashLevel, message string) {
+	if f, ok := r.Context().Value(flashKey{}).(*flashes); ok {
+		f.queued = append(f.queued, flash{Level: level, Message: message})
+	}
+}
+
+// popFlashes returns the pending messages and removes them, so they are
+// shown only once.
+func popFlashes(r *http.Request) []flash {
+	f, ok := r.Context().Value(flashKey{}).(*flashes)
+	if !ok {
+		return nil
+	}
+	shown := append(f.received, f.queued...)
+	f.received, f.queued, f.shown = nil, nil, true
+	return shown
+}
+
+type flashResponseWriter struct {
+	http.ResponseWriter
+	flashes *flashes
+	written bool
+}
+
+func (fw *flashResponseWriter) WriteHeader(status int) {
+	fw.setCookie()
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *flashResponseWriter) Write(b []byte) (int, error) {
+	fw.setCookie()
+	return fw.ResponseWriter.Write(b)
+}
+
+// setCookie keeps the messages not shown yet for the next request, or
+// clears the cookie once they have been shown.
+func (fw *flashResponseWriter) setCookie() {
+	if fw.written {
+		return
+	}
+	fw.written = true
+	f := fw.flashes
+	switch {
+	case len(f.queued) > 0:
+		http.SetCookie(fw, &http.Cookie{
+			Name:     flashCookie,
+			Value:    encodeFlashes(append(f.received, f.queued...)),
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	case f.shown:
+		http.SetCookie(fw, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+	}
+}
+
+// encodeFlashes serializes flashes into a signed value safe to keep in a
+// cookie.
+func encodeFlashes(flashes []flash) string {
+	b, _ := json.Marshal(flashes)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + service.Sign("flash", payload)
+}
+
+// decodeFlashes returns the flashes of a value made by encodeFlashes, or
+// nil when the value was tampered with.
+func decodeFlashes(value string) []flash {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(service.Sign("flash", payload))) {
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	var flashes []flash
+	if err := json.Unmarshal(b, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
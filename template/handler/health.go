@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// draining is set once the server is shutting down.
+var draining atomic.Bool
+
+// Drain makes the readiness probe fail, so that the load balancer stops
+// sending requests before the server stops accepting them.
+func Drain() {
+	draining.Store(true)
+}
+
+// healthResponse lists the result of each check, "ok" or what failed.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// GetHealthz answers as long as the process serves requests.
+func GetHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// GetReadyz tells whether the instance can take traffic: the database
+// answers, its schema is at the version of the binary and emails can be
+// sent. It fails from the start of the shutdown.
+func GetReadyz(conn *sql.DB, mailer *service.Mailer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		checks := map[string]string{
+			"shutdown":   "ok",
+			"database":   "ok",
+			"migrations": "ok",
+			"mailer":     "ok",
+		}
+		if draining.Load() {
+			checks["shutdown"] = "draining"
+		}
+		if err := conn.PingContext(ctx); err != nil {
+			checks["database"] = "unreachable"
+			checks["migrations"] = "unknown"
+			logger.WarnContext(r.Context(), "readiness: database unreachable", slog.String("error", err.Error()))
+		} else if current, latest, err := db.SchemaVersion(ctx, conn); err != nil {
+			checks["migrations"] = "unknown"
+			logger.WarnContext(r.Context(), "readiness: unable to read the schema version", slog.String("error", err.Error()))
+		} else if current != latest {
+			checks["migrations"] = fmt.Sprintf("version %d, expected %d", current, latest)
+		}
+		if !mailer.Configured() {
+			checks["mailer"] = "not configured"
+		}
+
+		resp := healthResponse{Status: "ok", Checks: checks}
+		status := http.StatusOK
+		for _, result := range checks {
+			if result != "ok" {
+				resp.Status, status = "unavailable", http.StatusServiceUnavailable
+				break
+			}
+		}
+		writeJSON(w, status, resp)
+	}
+}
+
+type versionResponse struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetVersion reports the build from the information the go command embeds
+// in the binary. The build time is the time of the commit built, the only
+// date recorded there.
+func GetVersion(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		writeJSON(w, http.StatusOK, versionResponse{Version: "unknown"})
+		return
+	}
+	resp := versionResponse{Version: info.Main.Version, GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			resp.Revision = setting.Value
+		case "vcs.time":
+			resp.BuildTime = setting.Value
+		case "vcs.modified":
+			resp.Modified = setting.Value == "true"
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
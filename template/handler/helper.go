@@ -1,39 +1,118 @@
 package handler
 
 import (
-	"html/template"
-	"io/fs"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
-	"{{projectName}}/web"
+	"time"
+	"{{projectName}}/form"
+	"{{projectName}}/service"
 )
 
-var (
-	PublicFS, _  = fs.Sub(web.WebFs, "template/public")
-	PrivateFs, _ = fs.Sub(web.WebFs, "template/private")
+// Error statuses without a body; errorPagesMiddleware shows the matching
+// error page. Use http.Error to show a specific message instead.
+func unprocessable(w http.ResponseWriter)   { w.WriteHeader(http.StatusUnprocessableEntity) }
+func unauthorized(w http.ResponseWriter)    { w.WriteHeader(http.StatusUnauthorized) }
+func badRequest(w http.ResponseWriter)      { w.WriteHeader(http.StatusBadRequest) }
+func forbidden(w http.ResponseWriter)       { w.WriteHeader(http.StatusForbidden) }
+func internal(w http.ResponseWriter)        { w.WriteHeader(http.StatusInternalServerError) }
+func conflict(w http.ResponseWriter)        { w.WriteHeader(http.StatusConflict) }
+func tooManyRequests(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) }
+
+const (
+	accountSuspendedMessage = "Ce compte est suspendu. Contactez le support pour en savoir plus."
+	accountDeletedMessage   = "Ce compte a été supprimé. Contactez le support pour le récupérer."
 )
 
-func renderPublic(w http.ResponseWriter, data any, files ...string) {
-	t := template.Must(template.ParseFS(PublicFS, files...))
-	if err := t.Execute(w, data); err != nil {
-		internal(w)
-		return
+// fieldErrorMessages holds the user-facing text for service validation errors.
+var fieldErrorMessages = map[error]string{
+	service.ErrInvalidEmailFormat:       "Entrez une adresse e-mail valide.",
+	service.ErrEmailAlreadyInUse:        "Cette adresse e-mail est déjà utilisée.",
+	service.ErrPasswordTooShort:         "Le mot de passe est trop court.",
+	service.ErrPasswordTooLong:          "Le mot de passe est trop long.",
+	service.ErrPasswordTooWeak:          "Ce mot de passe est trop facile à deviner.",
+	service.ErrPasswordMismatch:         "Les mots de passe doivent être identiques.",
+	service.ErrPasswordContainsPersonal: "Le mot de passe ne doit pas contenir votre e-mail ni le nom du projet.",
+	service.ErrPasswordBreached:         "Ce mot de passe figure dans une fuite de données connue.",
+	service.ErrCurrentPasswordInvalid:   "Le mot de passe actuel est incorrect.",
+	service.ErrEmailUnchanged:           "C'est déjà votre adresse e-mail.",
+	service.ErrConfirmationMismatch:     "Saisissez exactement votre adresse e-mail.",
+	service.ErrInvalidOrgName:           "Le nom doit contenir entre 2 et 64 caractères.",
+	service.ErrInvalidTokenName:         "Le nom doit contenir entre 1 et 64 caractères.",
+	service.ErrInvalidTokenScope:        "Choisissez au moins une autorisation.",
+	service.ErrInvalidTokenExpiry:       "Choisissez une des durées proposées.",
+}
+
+// ruleMessages holds the text for the form package's validation rules,
+// formatted with the rule's parameter.
+var ruleMessages = map[string]string{
+	"required": "Ce champ est obligatoire.",
+	"email":    "Entrez une adresse e-mail valide.",
+	"min":      "Ce champ doit contenir au moins %s caractères.",
+	"max":      "Ce champ doit contenir au plus %s caractères.",
+	"oneof":    "Choisissez une des valeurs proposées.",
+	"eqfield":  "Les deux valeurs doivent être identiques.",
+}
+
+func fieldMessages(fields service.FieldErrors) map[string]string {
+	messages := make(map[string]string, len(fields))
+	for field, err := range fields {
+		messages[field] = fieldMessage(err)
 	}
+	return messages
 }
 
-func renderPrivate(w http.ResponseWriter, data any, files ...string) {
-	t := template.Must(template.ParseFS(PrivateFs, files...))
-	if err := t.Execute(w, data); err != nil {
-		internal(w)
-		return
+func fieldMessage(err error) string {
+	if msg, ok := fieldErrorMessages[err]; ok {
+		return msg
+	}
+	var rule *form.RuleError
+	if errors.As(err, &rule) {
+		if msg, ok := ruleMessages[rule.Rule]; ok {
+			if strings.Contains(msg, "%s") {
+				return fmt.Sprintf(msg, rule.Param)
+			}
+			return msg
+		}
+	}
+	if errors.Is(err, form.ErrInvalidValue) {
+		return "Cette valeur est invalide."
 	}
+	return err.Error()
 }
 
-func unprocessable(w http.ResponseWriter) { w.WriteHeader(http.StatusUnprocessableEntity) }
-func unauthorized(w http.ResponseWriter)  { w.WriteHeader(http.StatusUnauthorized) }
-func badRequest(w http.ResponseWriter)    { w.WriteHeader(http.StatusBadRequest) }
-func internal(w http.ResponseWriter)      { w.WriteHeader(http.StatusInternalServerError) }
-func conflict(w http.ResponseWriter)      { w.WriteHeader(http.StatusConflict) }
+func setSessionCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(24 * time.Hour.Seconds()),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "session", Path: "/", MaxAge: -1})
+}
+
+// setAdminSessionCookie keeps the admin's own session token while they
+// browse as another user, with the flags of the session cookie.
+func setAdminSessionCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(24 * time.Hour.Seconds()),
+	})
+}
+
+func clearAdminSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: adminSessionCookie, Path: "/", MaxAge: -1})
+}
 
 func hasEmptyString(w http.ResponseWriter, s ...string) bool {
 	for _, v := range s {
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// adminSessionCookie keeps the admin's own session while they browse as
+// another user.
+const adminSessionCookie = "admin_session"
+
+func PostImpersonate(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := r.Cookie("session")
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+
+		token, err := service.StartImpersonation(r.Context(), store, contextUser(r), r.PathValue("id"), r)
+		switch {
+		case err == nil:
+			setAdminSessionCookie(w, current.Value)
+			setSessionCookie(w, token)
+			http.Redirect(w, r, "/app", http.StatusSeeOther)
+		case errors.Is(err, service.ErrCannotModifySelf):
+			http.Error(w, "Impossible sur votre propre compte", http.StatusConflict)
+		case errors.Is(err, service.ErrCannotImpersonate):
+			http.Error(w, "Les comptes administrateurs ne peuvent pas être empruntés", http.StatusConflict)
+		case errors.Is(err, service.ErrAccountSuspended):
+			http.Error(w, "Ce compte est suspendu", http.StatusConflict)
+		case errors.Is(err, sql.ErrNoRows):
+			http.NotFound(w, r)
+		default:
+			logger.ErrorContext(r.Context(), "unable to start impersonation", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+// PostStopImpersonation ends the impersonation and puts the admin back on
+// their own session, or on the admin login page if it has expired.
+func PostStopImpersonation(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := r.Cookie("session")
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+		var adminToken string
+		if c, err := r.Cookie(adminSessionCookie); err == nil {
+			adminToken = c.Value
+		}
+
+		session, restored, err := service.StopImpersonation(r.Context(), store, current.Value, adminToken)
+		switch {
+		case errors.Is(err, service.ErrNotImpersonating):
+			http.Redirect(w, r, "/app", http.StatusSeeOther)
+			return
+		case err != nil:
+			logger.ErrorContext(r.Context(), "unable to stop impersonation", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+
+		clearAdminSessionCookie(w)
+		if !restored {
+			clearSessionCookie(w)
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+		setSessionCookie(w, adminToken)
+		http.Redirect(w, r, "/admin/users/"+session.UserID, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+type magicLinkPage struct {
+	Email string
+	Sent  bool
+	Error string
+	Token string
+}
+
+func GetMagicLink(w http.ResponseWriter, r *http.Request) {
+	renderPublic(w, r, magicLinkPage{}, "layout.html", "magic-link.html")
+}
+
+func PostMagicLink(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.FormValue("email")
+		tolowerall(&email)
+
+		err := service.RequestMagicLink(r.Context(), store, email, appURL, mailer)
+		if wantsJSON(r) {
+			if err != nil {
+				writeAPIError(w, r, logger, "unable to send magic link", err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, nil)
+			return
+		}
+		switch err {
+		case nil:
+			renderPublic(w, r, magicLinkPage{Email: email, Sent: true}, "layout.html", "magic-link.html")
+		case service.ErrInvalidEmailFormat:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderPublic(w, r, magicLinkPage{Email: email, Error: fieldErrorMessages[err]}, "layout.html", "magic-link.html")
+		default:
+			logger.ErrorContext(r.Context(), "unable to send magic link", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+// GetMagicLinkConfirm only renders a confirmation button: mail scanners that
+// prefetch the link must not be able to consume it.
+func GetMagicLinkConfirm(w http.ResponseWriter, r *http.Request) {
+	renderPublic(w, r, magicLinkPage{Token: r.URL.Query().Get("token")}, "layout.html", "magic-link-confirm.html")
+}
+
+func PostMagicLinkConfirm(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		u, err := service.ConsumeMagicLink(ctx, store, r.FormValue("token"))
+		if err == nil {
+			var cookieHash string
+			if cookieHash, err = service.CreateSession(ctx, store, u.ID, r); err == nil {
+				setSessionCookie(w, cookieHash)
+			}
+		}
+		if wantsJSON(r) {
+			if err != nil {
+				writeAPIError(w, r, logger, "unable to sign in with magic link", err)
+				return
+			}
+			writeJSON(w, http.StatusOK, UserResponse{newAPIUser(u)})
+			return
+		}
+
+		switch err {
+		case nil:
+		case service.ErrInvalidMagicLink:
+			w.WriteHeader(http.StatusUnauthorized)
+			renderPublic(w, r, magicLinkPage{Error: "Ce lien est invalide ou a expiré."}, "layout.html", "magic-link.html")
+			return
+		case service.ErrAccountSuspended:
+			http.Error(w, accountSuspendedMessage, http.StatusForbidden)
+			return
+		default:
+			logger.ErrorContext(r.Context(), "unable to sign in with magic link", slog.String("error", err.Error()))
+			internal(w)
+			return
+		}
+
+		http.Redirect(w, r, "/app", http.StatusSeeOther)
+	}
+}
@@ -2,20 +2,47 @@ package handler
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"{{projectName}}/audit"
 	"{{projectName}}/db"
+	"{{projectName}}/logctx"
+	"{{projectName}}/metrics"
 	"{{projectName}}/service"
+	"{{projectName}}/tracing"
 
 	"golang.org/x/time/rate"
 )
 
 type userctx string
 
-const userKey userctx = "user"
+const (
+	userKey         userctx = "user"
+	permissionsKey  userctx = "permissions"
+	impersonatorKey userctx = "impersonator"
+	orgKey          userctx = "org"
+	scopesKey       userctx = "scopes"
+)
+
+// orgCookie remembers the organization picked in the switcher.
+const orgCookie = "org"
+
+// orgContext holds the user's organizations and the one they work in.
+type orgContext struct {
+	current     *db.Membership
+	memberships []*db.Membership
+}
 
 type middleware func(http.Handler) http.Handler
 
@@ -68,7 +95,8 @@ func rateLimitMiddlewarePerIP(r rate.Limit, b int) middleware {
 			}
 
 			if !getVisitor(ip).Allow() {
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				metrics.RateLimited.WithLabelValues("ip").Inc()
+				tooManyRequests(w)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -76,6 +104,86 @@ func rateLimitMiddlewarePerIP(r rate.Limit, b int) middleware {
 	}
 }
 
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware keeps the X-Request-ID set by a proxy in front of the
+// application, or makes up one. It is sent back in the response and, through
+// logctx, added to the log lines of the request.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logctx.New(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, dots, dashes and
+// underscores, so that a client cannot forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func contextRequestID(r *http.Request) string {
+	return logctx.RequestID(r.Context())
+}
+
+// recoverMiddleware turns a panic into a logged 500, so that one broken
+// handler neither kills the connection nor goes unnoticed.
+func recoverMiddleware(logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logger.ErrorContext(r.Context(), "panic serving request",
+					slog.Any("panic", v),
+					slog.String("stack", string(debug.Stack())))
+				internal(w)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LogRoute serves mux and records the pattern it routes the request to,
+// which the log lines and the trace of the request then mention. prefix is
+// the path http.StripPrefix removed before mux, put back in the pattern,
+// e.g. "GET /admin/users/{id}" rather than "GET /users/{id}".
+func LogRoute(prefix string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			if method, path, ok := strings.Cut(pattern, " "); ok {
+				pattern = method + " " + prefix + path
+			} else {
+				pattern = prefix + pattern
+			}
+			logctx.SetRoute(r.Context(), pattern)
+			tracing.SetRoute(r.Context(), pattern)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
 func loggingMiddleware(logger *slog.Logger) middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -84,7 +192,17 @@ func loggingMiddleware(logger *slog.Logger) middleware {
 			next.ServeHTTP(lrw, r)
 			duration := time.Since(start)
 
-			logger.Info("http request handled",
+			// Unmatched paths share one label, so that scanners do not
+			// create a series per path.
+			route := logctx.Route(r.Context())
+			if route == "" {
+				route = "unmatched"
+			}
+			status := strconv.Itoa(lrw.statusCode)
+			metrics.HTTPRequests.WithLabelValues(route, status).Inc()
+			metrics.HTTPDuration.WithLabelValues(route, status).Observe(duration.Seconds())
+
+			logger.InfoContext(r.Context(), "http request handled",
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.Int("status", lrw.statusCode),
@@ -96,32 +214,155 @@ func loggingMiddleware(logger *slog.Logger) middleware {
 func authMiddleware(store db.Store, logger *slog.Logger) middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			unauthenticated := func() {
+				if wantsJSON(r) {
+					writeAPIError(w, r, nil, "", errUnauthenticated)
+					return
+				}
+				http.Redirect(w, r, "/connexion", http.StatusSeeOther)
+			}
+
 			sessionCookie, err := r.Cookie("session")
 			if err != nil || sessionCookie.Value == "" {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				unauthenticated()
 				return
 			}
 
 			session, err := store.GetByCookieHash(r.Context(), sessionCookie.Value)
 			if err != nil {
-				logger.Error("failed to get session by hash", slog.String("error", err.Error()))
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				logger.ErrorContext(r.Context(), "failed to get session by hash", slog.String("error", err.Error()))
+				unauthenticated()
 				return
 			}
 
 			user, err := store.GetUserByID(r.Context(), session.UserID)
 			if err != nil || user == nil {
-				logger.Error("failed to get user by ID", slog.String("error", err.Error()))
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				logger.ErrorContext(r.Context(), "failed to get user by ID", slog.String("error", err.Error()))
+				unauthenticated()
+				return
+			}
+
+			if user.Status != db.UserStatusActive {
+				if wantsJSON(r) {
+					writeAPIError(w, r, nil, "", service.ErrAccountSuspended)
+					return
+				}
+				http.Error(w, accountSuspendedMessage, http.StatusForbidden)
+				return
+			}
+
+			perms, err := service.UserPermissions(r.Context(), store, user)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "failed to get user permissions", slog.String("error", err.Error()))
+				internal(w)
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), userKey, user)
+			ctx = context.WithValue(ctx, permissionsKey, perms)
+			ctx = audit.WithActor(ctx, user.ID)
+			logctx.SetUser(ctx, user.ID)
+
+			if session.ImpersonatorID != "" {
+				impersonator, err := store.GetUserByID(r.Context(), session.ImpersonatorID)
+				if err != nil {
+					logger.ErrorContext(r.Context(), "failed to get impersonator", slog.String("error", err.Error()))
+					internal(w)
+					return
+				}
+				ctx = context.WithValue(ctx, impersonatorKey, impersonator)
+				ctx = audit.WithImpersonator(ctx, impersonator.ID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerAuthMiddleware authenticates machine clients with a personal access
+// token sent as "Authorization: Bearer <token>". It fills the context like
+// authMiddleware does, along with the scopes of the token.
+func bearerAuthMiddleware(store db.Store, logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeAPIError(w, r, nil, "", errUnauthenticated)
+				return
+			}
+
+			t, err := service.AuthenticateAPIToken(r.Context(), store, strings.TrimSpace(token))
+			if err != nil {
+				if errors.Is(err, service.ErrInvalidAPIToken) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				}
+				writeAPIError(w, r, logger, "failed to authenticate api token", err)
+				return
+			}
+
+			user, err := store.GetUserByID(r.Context(), t.UserID)
+			if err != nil {
+				writeAPIError(w, r, logger, "failed to get user by ID", err)
+				return
+			}
+			if user.Status != db.UserStatusActive {
+				writeAPIError(w, r, nil, "", service.ErrAccountSuspended)
+				return
+			}
+
+			perms, err := service.UserPermissions(r.Context(), store, user)
+			if err != nil {
+				writeAPIError(w, r, logger, "failed to get user permissions", err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey, user)
+			ctx = context.WithValue(ctx, permissionsKey, perms)
+			ctx = context.WithValue(ctx, scopesKey, t.Scopes)
+			ctx = audit.WithActor(ctx, user.ID)
+			logctx.SetUser(ctx, user.ID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// currentOrgMiddleware loads the user's organizations and selects the
+// current one from the X-Organization header or the org cookie, which
+// becomes the tenant of the request context. It must run after
+// authMiddleware.
+func currentOrgMiddleware(store db.Store, logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			memberships, err := store.GetUserMemberships(r.Context(), contextUser(r).ID)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "failed to get user organizations", slog.String("error", err.Error()))
+				internal(w)
+				return
+			}
+
+			orgID := r.Header.Get("X-Organization")
+			if cookie, err := r.Cookie(orgCookie); err == nil && orgID == "" {
+				orgID = cookie.Value
+			}
+			org := orgContext{current: service.CurrentMembership(memberships, orgID), memberships: memberships}
+			ctx := context.WithValue(r.Context(), orgKey, org)
+			if org.current != nil {
+				ctx = db.WithTenant(ctx, org.current.OrgID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// auditClientMiddleware makes the caller's IP address and user agent
+// available to the audit events recorded while serving the request.
+func auditClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.WithClient(r.Context(), service.GetIPAddressBytes(r), r.UserAgent())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		headers := w.Header()
@@ -160,11 +401,100 @@ func sessionRefreshMiddleware(store db.Store) middleware {
 	}
 }
 
-func onlyAdminMiddleware(next http.Handler) http.Handler {
+// RequirePermission only lets through users whose role grants permission.
+// It must run after authMiddleware.
+func RequirePermission(permission string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !contextPermissions(r).Has(permission) {
+				if wantsJSON(r) {
+					writeAPIError(w, r, nil, "", errForbidden)
+					return
+				}
+				forbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope only lets through API tokens granted scope. Cookie sessions
+// are not limited by scopes. It must run after the authentication
+// middleware.
+func RequireScope(scope string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scopes, ok := r.Context().Value(scopesKey).([]string); ok && !slices.Contains(scopes, scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="api", error="insufficient_scope", scope=%q`, scope))
+				writeAPIError(w, r, nil, "", errInsufficientScope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ForbidImpersonation rejects sensitive pages, such as the data export,
+// when an admin is acting as the user. Writes are already rejected by
+// readOnlyImpersonationMiddleware.
+func ForbidImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contextImpersonator(r) != nil {
+			forbidImpersonation(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// impersonationWrites are the only writes an admin acting as a user can
+// make: ending the session.
+var impersonationWrites = []string{"/impersonation/stop", "/app/deconnexion"}
+
+// readOnlyImpersonationMiddleware lets an admin acting as a user look
+// around but not change the account, its tokens or its organizations.
+func readOnlyImpersonationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := contextUser(r)
-		if user == nil || user.Role != "admin" {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if contextImpersonator(r) != nil && !safe && !slices.Contains(impersonationWrites, r.URL.Path) {
+			forbidImpersonation(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func forbidImpersonation(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeAPIError(w, r, nil, "", service.ErrImpersonating)
+		return
+	}
+	http.Error(w, "Action indisponible pendant une session d'assistance", http.StatusForbidden)
+}
+
+// csrfMiddleware rejects the writes of a signed-in browser that do not
+// carry the token of its session, in the csrf_token field of the form or
+// the X-CSRF-Token header for scripts. Without a session cookie there is
+// nothing to forge and the request goes on to authentication.
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		want := csrfToken(r)
+		if want == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := r.Header.Get("X-CSRF-Token")
+		if got == "" {
+			got = r.PostFormValue("csrf_token")
+		}
+		if !hmac.Equal([]byte(got), []byte(want)) {
+			http.Error(w, "Formulaire expiré, rechargez la page et réessayez", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -179,7 +509,7 @@ func mustBeVerifyMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if !u.Verify && r.URL.Path != "/verify" {
+		if !u.Verify && r.URL.Path != "/verify" && r.URL.Path != "/verify/resend" {
 			unauthorized(w)
 			return
 		}
@@ -195,19 +525,128 @@ func contextUser(r *http.Request) *db.User {
 	return nil
 }
 
+// contextImpersonator returns the admin acting as the current user, or nil.
+func contextImpersonator(r *http.Request) *db.User {
+	if user, ok := r.Context().Value(impersonatorKey).(*db.User); ok {
+		return user
+	}
+	return nil
+}
+
+// contextMembership returns the user's membership of the current
+// organization, or nil when they belong to none.
+func contextMembership(r *http.Request) *db.Membership {
+	org, _ := r.Context().Value(orgKey).(orgContext)
+	return org.current
+}
+
+func contextMemberships(r *http.Request) []*db.Membership {
+	org, _ := r.Context().Value(orgKey).(orgContext)
+	return org.memberships
+}
+
+func contextPermissions(r *http.Request) service.Permissions {
+	if perms, ok := r.Context().Value(permissionsKey).(service.Permissions); ok {
+		return perms
+	}
+	return nil
+}
+
 func AllRouteMiddleware(logger *slog.Logger) []middleware {
 	return []middleware{
+		tracing.Middleware,
+		requestIDMiddleware,
 		loggingMiddleware(logger),
+		flashMiddleware,
+		errorPagesMiddleware(logger),
+		recoverMiddleware(logger),
 		securityHeadersMiddleware,
+		auditClientMiddleware,
 		rateLimitMiddlewarePerIP(rate.Every(time.Second), 10),
 	}
 }
 
+func UserMiddleware(store db.Store, logger *slog.Logger) []middleware {
+	return []middleware{
+		csrfMiddleware,
+		sessionRefreshMiddleware(store),
+		authMiddleware(store, logger),
+		readOnlyImpersonationMiddleware,
+		currentOrgMiddleware(store, logger),
+	}
+}
+
+// apiAuthMiddleware authenticates API calls with the bearer token when
+// there is an Authorization header, and with the session cookie otherwise.
+func apiAuthMiddleware(store db.Store, logger *slog.Logger) middleware {
+	bearer, session := bearerAuthMiddleware(store, logger), authMiddleware(store, logger)
+	return func(next http.Handler) http.Handler {
+		withBearer, withSession := bearer(next), session(apiCSRFMiddleware(next))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				withBearer.ServeHTTP(w, r)
+				return
+			}
+			withSession.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiCSRFMiddleware is csrfMiddleware for the API routes called with the
+// session cookie of a browser: writes must carry the X-CSRF-Token header,
+// which every response of such a route hands to the scripts of the page.
+// Bearer tokens are never sent by the browser on its own and skip it.
+func apiCSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := csrfToken(r)
+		w.Header().Set("X-CSRF-Token", want)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !hmac.Equal([]byte(r.Header.Get("X-CSRF-Token")), []byte(want)) {
+			writeAPIError(w, r, nil, "", errCSRFToken)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PublicAPIMiddleware serves the API routes that need no authentication,
+// such as signing in.
+func PublicAPIMiddleware() []middleware {
+	return []middleware{jsonFormMiddleware}
+}
+
+// APIMiddleware authenticates API routes with a bearer token or a session.
+func APIMiddleware(store db.Store, logger *slog.Logger) []middleware {
+	return []middleware{
+		jsonFormMiddleware,
+		sessionRefreshMiddleware(store),
+		apiAuthMiddleware(store, logger),
+		readOnlyImpersonationMiddleware,
+		currentOrgMiddleware(store, logger),
+	}
+}
+
+// AdminAPIMiddleware authenticates admins by session, before their OTP is
+// verified: only the verification routes use it.
+func AdminAPIMiddleware(store db.Store, logger *slog.Logger) []middleware {
+	return []middleware{
+		jsonFormMiddleware,
+		sessionRefreshMiddleware(store),
+		authMiddleware(store, logger),
+		RequirePermission("admin:access"),
+	}
+}
+
 func AdminMiddleware(store db.Store, logger *slog.Logger) []middleware {
 	return []middleware{
+		csrfMiddleware,
 		sessionRefreshMiddleware(store),
 		authMiddleware(store, logger),
-		onlyAdminMiddleware,
+		RequirePermission("admin:access"),
 		mustBeVerifyMiddleware,
 	}
 }
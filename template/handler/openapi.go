@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"{{projectName}}/openapi"
+	"{{projectName}}/web"
+)
+
+// GetOpenAPI serves the OpenAPI document generated by go generate.
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(web.OpenAPI)
+}
+
+type apiDocsPage struct {
+	Title      string
+	Version    string
+	Operations []apiDocsOperation
+	Schemas    []apiDocsSchema
+}
+
+type apiDocsOperation struct {
+	Method    string
+	Path      string
+	Summary   string
+	Security  []string
+	Request   string
+	Responses []apiDocsField
+}
+
+type apiDocsSchema struct {
+	Name   string
+	Fields []apiDocsField
+}
+
+// apiDocsField is a schema property, or a response when Name is a status.
+type apiDocsField struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// GetAPIDocs renders the OpenAPI document as a page, without any script.
+func GetAPIDocs(w http.ResponseWriter, r *http.Request) {
+	var doc openapi.Document
+	if err := json.Unmarshal(web.OpenAPI, &doc); err != nil {
+		internal(w)
+		return
+	}
+
+	page := apiDocsPage{Title: doc.Info.Title, Version: doc.Info.Version}
+	for _, p := range slices.Sorted(maps.Keys(doc.Paths)) {
+		for _, method := range slices.Sorted(maps.Keys(doc.Paths[p])) {
+			op := doc.Paths[p][method]
+			o := apiDocsOperation{Method: strings.ToUpper(method), Path: p, Summary: op.Summary}
+			for _, requirement := range op.Security {
+				for name, scopes := range requirement {
+					if len(scopes) > 0 {
+						name += " (" + strings.Join(scopes, ", ") + ")"
+					}
+					o.Security = append(o.Security, name)
+				}
+			}
+			if op.RequestBody != nil {
+				o.Request = schemaLabel(op.RequestBody.Content["application/json"].Schema)
+			}
+			for _, status := range slices.Sorted(maps.Keys(op.Responses)) {
+				resp := op.Responses[status]
+				label := resp.Description
+				if media, ok := resp.Content["application/json"]; ok {
+					label += " : " + schemaLabel(media.Schema)
+				}
+				o.Responses = append(o.Responses, apiDocsField{Name: status, Type: label})
+			}
+			page.Operations = append(page.Operations, o)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(doc.Components.Schemas)) {
+		s := doc.Components.Schemas[name]
+		schema := apiDocsSchema{Name: name}
+		for _, field := range slices.Sorted(maps.Keys(s.Properties)) {
+			schema.Fields = append(schema.Fields, apiDocsField{
+				Name:     field,
+				Type:     schemaLabel(s.Properties[field]),
+				Required: slices.Contains(s.Required, field),
+			})
+		}
+		page.Schemas = append(page.Schemas, schema)
+	}
+
+	renderPublic(w, r, page, "layout.html", "api-docs.html")
+}
+
+// schemaLabel describes a schema in a few words, e.g. "string[]".
+func schemaLabel(s *openapi.Schema) string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Ref != "":
+		return path.Base(s.Ref)
+	case s.Items != nil:
+		return schemaLabel(s.Items) + "[]"
+	case s.AdditionalProperties != nil:
+		return "map[string]" + schemaLabel(s.AdditionalProperties)
+	}
+	label := fmt.Sprint(s.Type)
+	if types, ok := s.Type.([]any); ok {
+		parts := make([]string, len(types))
+		for i, t := range types {
+			parts[i] = fmt.Sprint(t)
+		}
+		label = strings.Join(parts, " | ")
+	}
+	if s.Format != "" {
+		label += " (" + s.Format + ")"
+	}
+	return label
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+type organizationsPage struct {
+	Name   string
+	Errors map[string]string
+}
+
+func GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	renderPrivate(w, r, organizationsPage{}, "app-layout.html", "app-organizations.html")
+}
+
+func PostOrganization(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+
+		o, err := service.CreateOrganization(r.Context(), store, contextUser(r), name)
+		switch {
+		case err == nil:
+			setOrgCookie(w, o.ID)
+			addFlash(r, flashSuccess, "Organisation créée.")
+			http.Redirect(w, r, "/app/organisation", http.StatusSeeOther)
+		case errors.Is(err, service.ErrInvalidOrgName):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			page := organizationsPage{Name: name, Errors: fieldMessages(service.FieldErrors{"name": err})}
+			renderPrivate(w, r, page, "app-layout.html", "app-organizations.html")
+		default:
+			logger.ErrorContext(r.Context(), "unable to create organization", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+// PostSwitchOrganization is the org switcher. Only organizations the user
+// belongs to can be picked.
+func PostSwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID := r.FormValue("org_id")
+	for _, m := range contextMemberships(r) {
+		if m.OrgID == orgID {
+			setOrgCookie(w, orgID)
+			http.Redirect(w, r, "/app/organisation", http.StatusSeeOther)
+			return
+		}
+	}
+	http.Error(w, "Organisation inconnue", http.StatusForbidden)
+}
+
+func setOrgCookie(w http.ResponseWriter, orgID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     orgCookie,
+		Value:    orgID,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+type orgPage struct {
+	*service.OrgPage
+	Email  string
+	Errors map[string]string
+}
+
+func (p orgPage) CanManage() bool { return service.CanManageOrg(p.Membership) }
+
+func GetOrganization(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderOrganization(w, r, store, logger, orgPage{})
+	}
+}
+
+func renderOrganization(w http.ResponseWriter, r *http.Request, store db.AuthStore, logger *slog.Logger, page orgPage) {
+	m := contextMembership(r)
+	if m == nil {
+		http.Redirect(w, r, "/app/organisations", http.StatusSeeOther)
+		return
+	}
+	var err error
+	if page.OrgPage, err = service.GetOrgPage(r.Context(), store, m); err != nil {
+		logger.ErrorContext(r.Context(), "unable to get organization", slog.String("error", err.Error()))
+		internal(w)
+		return
+	}
+	renderPrivate(w, r, page, "app-layout.html", "app-organization.html")
+}
+
+// orgAction adapts a service call on the current organization to a form
+// handler that goes back to the organization page, with the success flash
+// message.
+func orgAction(name, success string, store db.AuthStore, logger *slog.Logger, action func(r *http.Request, m *db.Membership) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := contextMembership(r)
+		if m == nil {
+			http.Redirect(w, r, "/app/organisations", http.StatusSeeOther)
+			return
+		}
+
+		err := action(r, m)
+		var fields service.FieldErrors
+		switch {
+		case err == nil:
+			addFlash(r, flashSuccess, success)
+			http.Redirect(w, r, "/app/organisation", http.StatusSeeOther)
+		case errors.As(err, &fields):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderOrganization(w, r, store, logger, orgPage{Email: r.FormValue("email"), Errors: fieldMessages(fields)})
+		case errors.Is(err, service.ErrOrgForbidden):
+			http.Error(w, "Votre rôle dans l'organisation ne le permet pas", http.StatusForbidden)
+		case errors.Is(err, service.ErrLastOwner):
+			http.Error(w, "L'organisation doit garder au moins un propriétaire", http.StatusConflict)
+		case errors.Is(err, service.ErrInvalidOrgRole):
+			unprocessable(w)
+		case errors.Is(err, service.ErrNotOrgMember):
+			http.NotFound(w, r)
+		default:
+			logger.ErrorContext(r.Context(), "unable to perform organization action", slog.String("action", name), slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostInviteMember(store db.AuthStore, logger *slog.Logger, mailer *service.Mailer, appURL string) http.HandlerFunc {
+	return orgAction("invite", "Invitation envoyée.", store, logger, func(r *http.Request, m *db.Membership) error {
+		email := strings.TrimSpace(r.FormValue("email"))
+		tolowerall(&email)
+		return service.InviteMember(r.Context(), store, m, email, r.FormValue("role"), appURL, mailer)
+	})
+}
+
+func PostRevokeInvitation(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return orgAction("revoke_invitation", "Invitation annulée.", store, logger, func(r *http.Request, m *db.Membership) error {
+		return service.RevokeInvitation(r.Context(), store, m, r.PathValue("id"))
+	})
+}
+
+func PostMemberRole(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return orgAction("change_member_role", "Rôle modifié.", store, logger, func(r *http.Request, m *db.Membership) error {
+		return service.ChangeMemberRole(r.Context(), store, m, r.PathValue("id"), r.FormValue("role"))
+	})
+}
+
+func PostRemoveMember(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return orgAction("remove_member", "Membre retiré de l'organisation.", store, logger, func(r *http.Request, m *db.Membership) error {
+		return service.RemoveMember(r.Context(), store, m, r.PathValue("id"))
+	})
+}
+
+func PostLeaveOrganization(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return orgAction("leave", "Vous avez quitté l'organisation.", store, logger, func(r *http.Request, m *db.Membership) error {
+		return service.LeaveOrganization(r.Context(), store, m)
+	})
+}
+
+type invitationPage struct {
+	Token      string
+	Invitation *db.Invitation
+	Error      string
+}
+
+// GetInvitation shows an invitation to anyone holding its link; accepting
+// it requires signing in with the invited email.
+func GetInvitation(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		i, err := service.GetInvitation(r.Context(), store, token)
+		switch {
+		case err == nil:
+			renderPublic(w, r, invitationPage{Token: token, Invitation: i}, "layout.html", "invitation.html")
+		case errors.Is(err, service.ErrInvalidInvitation):
+			w.WriteHeader(http.StatusNotFound)
+			renderPublic(w, r, invitationPage{Error: "Cette invitation est invalide ou a expiré."}, "layout.html", "invitation.html")
+		default:
+			logger.ErrorContext(r.Context(), "unable to get invitation", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
+
+func PostAcceptInvitation(store db.AuthStore, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i, err := service.AcceptInvitation(r.Context(), store, contextUser(r), r.FormValue("token"))
+		switch {
+		case err == nil:
+			setOrgCookie(w, i.OrgID)
+			addFlash(r, flashSuccess, "Vous avez rejoint l'organisation.")
+			http.Redirect(w, r, "/app/organisation", http.StatusSeeOther)
+		case errors.Is(err, service.ErrInvalidInvitation):
+			w.WriteHeader(http.StatusNotFound)
+			renderPublic(w, r, invitationPage{Error: "Cette invitation est invalide ou a expiré."}, "layout.html", "invitation.html")
+		case errors.Is(err, service.ErrInvitationEmailMismatch):
+			w.WriteHeader(http.StatusForbidden)
+			renderPublic(w, r, invitationPage{Error: "Cette invitation a été envoyée à une autre adresse e-mail."}, "layout.html", "invitation.html")
+		case errors.Is(err, service.ErrEmailNotVerified):
+			w.WriteHeader(http.StatusForbidden)
+			renderPublic(w, r, invitationPage{Error: "Confirmez d'abord votre adresse e-mail : connectez-vous avec un lien de connexion, puis rouvrez l'invitation."}, "layout.html", "invitation.html")
+		default:
+			logger.ErrorContext(r.Context(), "unable to accept invitation", slog.String("error", err.Error()))
+			internal(w)
+		}
+	}
+}
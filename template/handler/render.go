@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// TemplateConfig tells LoadTemplates where the templates and static files
+// are. Templates holds the public and private trees, plus the partials
+// shared by both.
+type TemplateConfig struct {
+	Templates fs.FS
+	Static    fs.FS
+	// Reload parses the templates again on every render, so that edits
+	// show up without a restart. Meant for development with the files
+	// read from disk.
+	Reload bool
+	Logger *slog.Logger
+}
+
+// Template trees. A tree's layouts are the files ending in layout.html,
+// every other file is a page rendered inside one of them.
+const (
+	publicTree   = "public"
+	privateTree  = "private"
+	partialsTree = "partials"
+)
+
+// renderer holds the parsed pages, keyed by tree and file name.
+type renderer struct {
+	cfg TemplateConfig
+
+	mu     sync.RWMutex
+	pages  map[string]*template.Template
+	assets map[string]string
+}
+
+var pages *renderer
+
+// LoadTemplates parses every template once, so that a broken template
+// stops the application at startup instead of failing a request.
+func LoadTemplates(cfg TemplateConfig) error {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	rd := &renderer{cfg: cfg}
+	if err := rd.parse(); err != nil {
+		return err
+	}
+	pages = rd
+	return nil
+}
+
+func (rd *renderer) parse() error {
+	partials, err := fs.Glob(rd.cfg.Templates, partialsTree+"/*.html")
+	if err != nil {
+		return err
+	}
+	parsed := map[string]*template.Template{}
+	for _, tree := range []string{publicTree, privateTree} {
+		files, err := fs.Glob(rd.cfg.Templates, tree+"/*.html")
+		if err != nil {
+			return err
+		}
+		var layouts []string
+		for _, file := range files {
+			if strings.HasSuffix(file, "layout.html") {
+				layouts = append(layouts, file)
+			}
+		}
+		base, err := template.New(tree).Funcs(baseFuncs).ParseFS(rd.cfg.Templates, append(layouts, partials...)...)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "layout.html") {
+				continue
+			}
+			t, err := template.Must(base.Clone()).ParseFS(rd.cfg.Templates, file)
+			if err != nil {
+				return err
+			}
+			parsed[tree+"/"+path.Base(file)] = t
+		}
+	}
+
+	rd.mu.Lock()
+	rd.pages, rd.assets = parsed, map[string]string{}
+	rd.mu.Unlock()
+	return nil
+}
+
+func (rd *renderer) page(tree, name string) (*template.Template, error) {
+	if rd.cfg.Reload {
+		if err := rd.parse(); err != nil {
+			return nil, err
+		}
+	}
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	t, ok := rd.pages[tree+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("template %s/%s does not exist", tree, name)
+	}
+	return t, nil
+}
+
+// execute runs page inside layout into a buffer, so that a failing
+// template answers a clean error instead of half a page.
+func (rd *renderer) execute(w http.ResponseWriter, r *http.Request, tree, layout, page string, data any) (*bytes.Buffer, error) {
+	if rd == nil {
+		return nil, errors.New("templates are not loaded")
+	}
+	t, err := rd.page(tree, page)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = t.Clone(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Funcs(rd.requestFuncs(r)).ExecuteTemplate(&buf, layout, data); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (rd *renderer) render(w http.ResponseWriter, r *http.Request, tree, layout, page string, data any) {
+	buf, err := rd.execute(w, r, tree, layout, page, data)
+	if err != nil {
+		logger := slog.Default()
+		if rd != nil {
+			logger = rd.cfg.Logger
+		}
+		logger.ErrorContext(r.Context(), "unable to render template",
+			slog.String("template", tree+"/"+page),
+			slog.String("error", err.Error()))
+		internal(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+func renderPublic(w http.ResponseWriter, r *http.Request, data any, layout, page string) {
+	pages.render(w, r, publicTree, layout, page, data)
+}
+
+func renderPrivate(w http.ResponseWriter, r *http.Request, data any, layout, page string) {
+	pages.render(w, r, privateTree, layout, page, data)
+}
+
+// baseFuncs declares the functions available to every template. The ones
+// depending on the request are replaced by requestFuncs at render time.
+var baseFuncs = template.FuncMap{
+	"csrf":          func() string { return "" },
+	"flash":         func() []flash { return nil },
+	"urlFor":        urlFor,
+	"asset":         func(name string) string { return "/static/" + name },
+	"t":             func(s string, args ...any) string { return s },
+	"lang":          func() string { return defaultLanguage },
+	"can":           func(string) bool { return false },
+	"currentUser":   func() *db.User { return nil },
+	"impersonator":  func() *db.User { return nil },
+	"currentOrg":    func() *db.Membership { return nil },
+	"organizations": func() []*db.Membership { return nil },
+}
+
+// requestFuncs gives the templates the flash messages, the CSRF token and
+// language of the request, along with the signed-in user, the admin
+// impersonating them, their permissions (e.g. {{if can "users:write"}}) and
+// their organizations.
+func (rd *renderer) requestFuncs(r *http.Request) template.FuncMap {
+	flashes := popFlashes(r)
+	perms := contextPermissions(r)
+	lang := requestLanguage(r)
+	return template.FuncMap{
+		"csrf":          func() string { return csrfToken(r) },
+		"flash":         func() []flash { return flashes },
+		"asset":         rd.asset,
+		"t":             func(s string, args ...any) string { return translate(lang, s, args...) },
+		"lang":          func() string { return lang },
+		"can":           perms.Has,
+		"currentUser":   func() *db.User { return contextUser(r) },
+		"impersonator":  func() *db.User { return contextImpersonator(r) },
+		"currentOrg":    func() *db.Membership { return contextMembership(r) },
+		"organizations": func() []*db.Membership { return contextMemberships(r) },
+	}
+}
+
+// asset returns the URL of a static file with a hash of its content, so
+// that browsers fetch it again whenever it changes.
+func (rd *renderer) asset(name string) string {
+	rd.mu.RLock()
+	url, ok := rd.assets[name]
+	rd.mu.RUnlock()
+	if ok {
+		return url
+	}
+
+	url = "/static/" + name
+	if rd.cfg.Static != nil {
+		if b, err := fs.ReadFile(rd.cfg.Static, name); err == nil {
+			sum := sha256.Sum256(b)
+			url += "?v=" + hex.EncodeToString(sum[:4])
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			rd.cfg.Logger.Warn("unable to read asset", slog.String("asset", name), slog.String("error", err.Error()))
+		}
+	}
+	rd.mu.Lock()
+	rd.assets[name] = url
+	rd.mu.Unlock()
+	return url
+}
+
+// csrfToken is the token of the signed-in session, for forms and scripts
+// to send back; it is empty without a session.
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return ""
+	}
+	return service.CSRFToken(cookie.Value)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The interface is written in French; its text is the key of the other
+// languages' translations. Texts without a translation are shown in French.
+const defaultLanguage = "fr"
+
+var translations = map[string]map[string]string{
+	"en": {
+		"Mon compte":            "My account",
+		"Organisation":          "Organization",
+		"Jetons d'accès":        "Access tokens",
+		"Organisation courante": "Current organization",
+		"Changer":               "Switch",
+		"Se déconnecter":        "Sign out",
+		"Utilisateurs":          "Users",
+		"Rôles":                 "Roles",
+		"Verrouillages":         "Lockouts",
+		"Audit":                 "Audit",
+		"Administration":        "Administration",
+	},
+}
+
+// requestLanguage picks the first language of Accept-Language that has
+// translations, or the default one.
+func requestLanguage(r *http.Request) string {
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang, _, _ := strings.Cut(strings.TrimSpace(tag), ";")
+		lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+		if lang == defaultLanguage {
+			return lang
+		}
+		if _, ok := translations[lang]; ok {
+			return lang
+		}
+	}
+	return defaultLanguage
+}
+
+// translate returns text in lang, formatted with args when given:
+// {{t "Inscrit le %s" .Date}}.
+func translate(lang, text string, args ...any) string {
+	if translated, ok := translations[lang][text]; ok {
+		text = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// routeNames maps the names templates use with urlFor to the paths of
+// main.go, so that a path changes in two places rather than in every
+// template.
+var routeNames = map[string]string{
+	"home":                "/",
+	"register":            "/inscription",
+	"login":               "/connexion",
+	"magic-link":          "/connexion/lien",
+	"google-login":        "/auth/google/login",
+	"account":             "/app",
+	"logout":              "/app/deconnexion",
+	"tokens":              "/app/jetons",
+	"organization":        "/app/organisation",
+	"organizations":       "/app/organisations",
+	"switch-organization": "/app/organisations/courante",
+	"stop-impersonation":  "/impersonation/stop",
+	"admin-login":         "/admin/login",
+	"admin-verify":        "/admin/verify",
+	"admin-users":         "/admin/dashboard",
+	"admin-user":          "/admin/users/{id}",
+	"admin-roles":         "/admin/roles",
+	"admin-lockouts":      "/admin/lockouts",
+	"admin-audit":         "/admin/audit",
+	"api-docs":            "/api/docs",
+}
+
+// urlFor returns the path of a named route, its {wildcards} replaced by
+// args in order: {{urlFor "admin-user" .ID}}.
+func urlFor(name string, args ...any) (string, error) {
+	pattern, ok := routeNames[name]
+	if !ok {
+		return "", fmt.Errorf("urlFor: unknown route %q", name)
+	}
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		if len(args) == 0 {
+			return "", fmt.Errorf("urlFor: missing %s for route %q", segment, name)
+		}
+		segments[i], args = url.PathEscape(fmt.Sprint(args[0])), args[1:]
+	}
+	if len(args) > 0 {
+		return "", fmt.Errorf("urlFor: too many arguments for route %q", name)
+	}
+	return strings.Join(segments, "/"), nil
+}
@@ -0,0 +1,116 @@
+// Package logctx correlates log lines with the request they come from.
+//
+// The request ID middleware stores the request's fields in its context,
+// completed as the request goes through authentication and routing, and
+// Handler adds them to every record logged with that context:
+//
+//	logger.ErrorContext(r.Context(), "unable to log in", ...)
+//
+// logs request_id, user_id and route along with the message, and the
+// trace_id and span_id of the span in the context.
+package logctx
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fieldsKey struct{}
+
+// fields are shared by the contexts derived from the request's, so that
+// the user found by a middleware is known to the logs of the middlewares
+// wrapping it.
+type fields struct {
+	mu        sync.Mutex
+	requestID string
+	userID    string
+	route     string
+}
+
+// New returns a context carrying the fields of the request requestID.
+func New(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, &fields{requestID: requestID})
+}
+
+func from(ctx context.Context) *fields {
+	f, _ := ctx.Value(fieldsKey{}).(*fields)
+	return f
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	if f := from(ctx); f != nil {
+		return f.requestID
+	}
+	return ""
+}
+
+// SetUser records the signed-in user of the request.
+func SetUser(ctx context.Context, userID string) {
+	if f := from(ctx); f != nil {
+		f.mu.Lock()
+		f.userID = userID
+		f.mu.Unlock()
+	}
+}
+
+// SetRoute records the ServeMux pattern serving the request.
+func SetRoute(ctx context.Context, pattern string) {
+	if f := from(ctx); f != nil {
+		f.mu.Lock()
+		f.route = pattern
+		f.mu.Unlock()
+	}
+}
+
+// Route returns the ServeMux pattern serving the request, or "" when no
+// route matched.
+func Route(ctx context.Context) string {
+	if f := from(ctx); f != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.route
+	}
+	return ""
+}
+
+// Handler adds the request fields and the trace of the context to the
+// records passed to the wrapped handler.
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{Handler: h}
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if f := from(ctx); f != nil {
+		f.mu.Lock()
+		record.AddAttrs(slog.String("request_id", f.requestID))
+		if f.userID != "" {
+			record.AddAttrs(slog.String("user_id", f.userID))
+		}
+		if f.route != "" {
+			record.AddAttrs(slog.String("route", f.route))
+		}
+		f.mu.Unlock()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
@@ -9,16 +14,65 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	"{{projectName}}/audit"
 	"{{projectName}}/config"
 	"{{projectName}}/db"
 	"{{projectName}}/handler"
+	"{{projectName}}/metrics"
+	"{{projectName}}/openapi"
+	"{{projectName}}/service"
+	"{{projectName}}/tracing"
+	"{{projectName}}/utils"
 	"{{projectName}}/web"
 )
 
+//go:generate go run . -openapi web/openapi.json
+
 func main() {
+	openAPIOut := flag.String("openapi", "", "write the OpenAPI document of the API routes to `file` and exit")
+	openAPICheck := flag.Bool("openapi-check", false, "exit with an error when web/openapi.json does not match the API routes")
+	flag.Parse()
+
+	switch {
+	case *openAPIOut != "":
+		if err := writeOpenAPI(*openAPIOut); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case *openAPICheck:
+		if err := checkOpenAPI(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg := config.Load()
 	logger := config.NewSlog(cfg.Env)
 
+	if err := cfg.Password.Validate(); err != nil {
+		logger.Error("invalid password hashing configuration", slog.String("error", err.Error()))
+		return
+	}
+
+	if err := checkOpenAPI(); err != nil {
+		logger.Warn("the API documentation is out of date", slog.String("error", err.Error()))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.ServiceName, cfg.Tracing.Exporter, cfg.Tracing.File)
+	if err != nil {
+		logger.Error("unable to set up tracing", slog.String("error", err.Error()))
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("unable to flush traces", slog.String("error", err.Error()))
+		}
+	}()
+
 	conn, err := db.NewDB(cfg.Database.String())
 	if err != nil {
 		logger.Error("unable to connect to database", slog.String("error", err.Error()))
@@ -31,12 +85,75 @@ func main() {
 		return
 	}
 
+	if err := db.EnforceRowLevelSecurity(context.Background(), conn, cfg.Database.RowLevelSecurity); err != nil {
+		logger.Error("unable to configure row level security", slog.String("error", err.Error()))
+		return
+	}
+
+	utils.SetPasswordHasher(utils.NewPasswordHasher(utils.Argon2Params{
+		Memory:      uint32(cfg.Password.Memory),
+		Iterations:  uint32(cfg.Password.Iterations),
+		Parallelism: uint8(cfg.Password.Parallelism),
+		SaltLength:  uint32(cfg.Password.SaltLength),
+		KeyLength:   uint32(cfg.Password.KeyLength),
+	}))
+
+	policy := service.NewPasswordPolicy(cfg.Password.MinLength, cfg.Password.MinScore, cfg.Password.Banned...)
+	if cfg.Password.BreachedFile != "" {
+		if err := policy.LoadBreachedFile(cfg.Password.BreachedFile); err != nil {
+			logger.Error("unable to load breached password list", slog.String("error", err.Error()))
+			return
+		}
+	}
+	service.SetPasswordPolicy(policy)
+
 	if err := db.CreateSeed(conn); err != nil {
 		logger.Error("unable to seed admin data", slog.String("error", err.Error()))
 		return
 	}
 
-	r := newRouter(logger, db.NewPostgresStore(conn), cfg.Google, cfg.MAIlAPI)
+	if cfg.SecretKey == "" {
+		logger.Warn("SECRET_KEY is not set, using a random key: OTP codes will not survive a restart")
+	}
+	service.SetSecretKey(cfg.SecretKey)
+
+	if err := metrics.RegisterDB(conn, cfg.Database.Database); err != nil {
+		logger.Warn("unable to expose database metrics", slog.String("error", err.Error()))
+	}
+
+	store := db.NewPostgresStore(conn)
+	service.SetAuditRecorder(audit.NewRecorder(store, logger))
+	mailer := service.NewMailer(cfg.MAIlAPI, cfg.MailFrom)
+	if !mailer.Configured() {
+		logger.Warn("RESEND_API or MAIL_FROM is not set, emails will not be sent")
+	}
+	files, reload := webFiles(cfg.Env, logger)
+	templateFS, _ := fs.Sub(files, "template")
+	staticFS, _ := fs.Sub(files, "static")
+	if err := handler.LoadTemplates(handler.TemplateConfig{
+		Templates: templateFS,
+		Static:    staticFS,
+		Reload:    reload,
+		Logger:    logger,
+	}); err != nil {
+		logger.Error("unable to parse templates", slog.String("error", err.Error()))
+		return
+	}
+
+	r := newRouter(logger, store, cfg.Google, mailer, cfg.AppURL, staticFS)
+	// The metrics go on their own listener when one is configured, so that
+	// the public port does not serve them at all.
+	var metricsServer *http.Server
+	switch {
+	case cfg.Metrics.Addr != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler(cfg.Metrics.Token))
+		metricsServer = &http.Server{Addr: cfg.Metrics.Addr, Handler: metricsMux}
+	case cfg.Metrics.Token != "":
+		r.metricsToken = cfg.Metrics.Token
+	default:
+		logger.Warn("METRICS_ADDR and METRICS_TOKEN are not set, metrics will not be served")
+	}
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: r.route(),
@@ -45,17 +162,32 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	go service.RunJanitor(ctx, store, 10*time.Minute, logger)
+
 	go func() {
 		logger.Info("starting server", slog.String("port", cfg.Port))
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Error("unable to start server", slog.String("error", err.Error()))
 		}
 	}()
+	if metricsServer != nil {
+		go func() {
+			logger.Info("starting metrics server", slog.String("addr", metricsServer.Addr))
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("unable to start metrics server", slog.String("error", err.Error()))
+			}
+		}()
+	}
 
 	<-ctx.Done()
 	stop()
 	logger.Info("shutting down server...")
 
+	// Fail readiness first and keep serving while the load balancer
+	// notices, so that no request is sent to a closed listener.
+	handler.Drain()
+	time.Sleep(cfg.ShutdownDelay)
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -64,35 +196,71 @@ func main() {
 	} else {
 		logger.Info("server stopped gracefully")
 	}
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}
+}
+
+// webFiles returns the templates and static files. In development they are
+// read from disk when run from the project directory, and the templates
+// reloaded on every request; otherwise the embedded copies are used.
+func webFiles(env string, logger *slog.Logger) (files fs.FS, reload bool) {
+	if env != "development" {
+		return web.WebFs, false
+	}
+	if _, err := os.Stat("web/template"); err != nil {
+		logger.Warn("web/template not found, using the embedded templates")
+		return web.WebFs, false
+	}
+	return os.DirFS("web"), true
 }
 
 type router struct {
 	logger *slog.Logger
 	store  *db.PostgresStore
 	google *config.GoogleOAuth
-	mail   string
+	mailer *service.Mailer
+	appURL string
+	static fs.FS
+	// metricsToken serves the metrics on /metrics to the holders of the
+	// token; they are not served there when empty.
+	metricsToken string
 }
 
-func newRouter(logger *slog.Logger, store *db.PostgresStore, google *config.GoogleOAuth, mail string) *router {
-	return &router{logger: logger, store: store, google: google, mail: mail}
+func newRouter(logger *slog.Logger, store *db.PostgresStore, google *config.GoogleOAuth, mailer *service.Mailer, appURL string, static fs.FS) *router {
+	return &router{logger: logger, store: store, google: google, mailer: mailer, appURL: appURL, static: static}
 }
 
 func (r *router) route() http.Handler {
 	mux := http.NewServeMux()
 	r.setupStatic(mux)
 	r.setupPublic(mux)
+	r.setupApp(mux)
+	r.setupAPI(openapi.NewRouter(mux))
 	r.setupAdmin(mux)
+	mux.HandleFunc("GET /version", handler.GetVersion)
+	mux.HandleFunc("GET /api/openapi.json", handler.GetOpenAPI)
+	mux.HandleFunc("GET /api/docs", handler.GetAPIDocs)
+	if r.metricsToken != "" {
+		mux.Handle("GET /metrics", metrics.Handler(r.metricsToken))
+	}
+
+	root := http.NewServeMux()
+	r.setupHealth(root)
+	root.Handle("/", handler.Use(handler.LogRoute("", mux), handler.AllRouteMiddleware(r.logger)...))
+	return root
+}
 
-	return handler.Use(mux, handler.AllRouteMiddleware(r.logger)...)
+// setupHealth serves the probes of the orchestrator. They skip the global
+// middleware: polled every few seconds, they would fill the logs, metrics
+// and traces, and the rate limiter could fail them.
+func (r *router) setupHealth(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", handler.GetHealthz)
+	mux.HandleFunc("GET /readyz", handler.GetReadyz(r.store.DB, r.mailer, r.logger))
 }
 
 func (r *router) setupStatic(mux *http.ServeMux) {
-	staticFS, err := fs.Sub(web.WebFs, "static")
-	if err != nil {
-		r.logger.Error("unable to serve static files", slog.String("error", err.Error()))
-		return
-	}
-	mux.Handle("/static/", http.StripPrefix("/static", http.FileServerFS(staticFS)))
+	mux.Handle("/static/", http.StripPrefix("/static", http.FileServerFS(r.static)))
 }
 
 func (r *router) setupPublic(mux *http.ServeMux) {
@@ -101,19 +269,227 @@ func (r *router) setupPublic(mux *http.ServeMux) {
 	mux.HandleFunc("POST /inscription", handler.RegisterUser(r.store, r.logger))
 	mux.HandleFunc("GET /connexion", handler.GetLogin)
 	mux.HandleFunc("POST /connexion", handler.PostLogin(r.store, r.logger))
+	mux.HandleFunc("GET /connexion/lien", handler.GetMagicLink)
+	mux.HandleFunc("POST /connexion/lien", handler.PostMagicLink(r.store, r.logger, r.mailer, r.appURL))
+	mux.HandleFunc("GET /connexion/lien/verifier", handler.GetMagicLinkConfirm)
+	mux.HandleFunc("POST /connexion/lien/verifier", handler.PostMagicLinkConfirm(r.store, r.logger))
 	mux.HandleFunc("GET /auth/google/login", handler.HandleGoogleLogin(r.google.Oauth()))
 	mux.HandleFunc("GET /auth/google/callback", handler.HandleGoogleCallback(r.store, r.google.Oauth(), r.logger))
 
 	// ADMIN
 	mux.HandleFunc("GET /admin/login", handler.GetAdminLogin)
-	mux.HandleFunc("POST /admin/login", handler.PostAdminLogin(r.store, r.logger, r.mail))
+	mux.HandleFunc("POST /admin/login", handler.PostAdminLogin(r.store, r.logger, r.mailer))
+}
+
+// setupApp serves the user area. While an admin impersonates the user,
+// UserMiddleware rejects every write but logging out and ending the
+// impersonation.
+func (r *router) setupApp(mux *http.ServeMux) {
+	appMux := http.NewServeMux()
+	appMux.HandleFunc("GET /app", handler.GetAccount)
+	appMux.HandleFunc("POST /app/mot-de-passe", handler.PostChangePassword(r.store, r.logger))
+	appMux.HandleFunc("POST /app/email", handler.PostChangeEmail(r.store, r.logger, r.mailer, r.appURL))
+	appMux.HandleFunc("POST /app/supprimer", handler.PostDeleteAccount(r.store, r.logger, r.mailer))
+	appMux.HandleFunc("POST /app/supprimer/annuler", handler.PostCancelErasure(r.store, r.logger))
+	appMux.Handle("GET /app/donnees", handler.ForbidImpersonation(handler.GetDataExport(r.store, r.logger)))
+	appMux.HandleFunc("POST /app/deconnexion", handler.PostLogout(r.store, r.logger))
+	appMux.HandleFunc("GET /app/jetons", handler.GetAPITokens(r.store, r.logger))
+	appMux.HandleFunc("POST /app/jetons", handler.PostAPIToken(r.store, r.logger))
+	appMux.HandleFunc("POST /app/jetons/{id}/supprimer", handler.PostRevokeAPIToken(r.store, r.logger))
+	appMux.HandleFunc("GET /app/organisations", handler.GetOrganizations)
+	appMux.HandleFunc("POST /app/organisations", handler.PostOrganization(r.store, r.logger))
+	appMux.HandleFunc("POST /app/organisations/courante", handler.PostSwitchOrganization)
+	appMux.HandleFunc("GET /app/organisation", handler.GetOrganization(r.store, r.logger))
+	appMux.HandleFunc("POST /app/organisation/invitations", handler.PostInviteMember(r.store, r.logger, r.mailer, r.appURL))
+	appMux.HandleFunc("POST /app/organisation/invitations/{id}/supprimer", handler.PostRevokeInvitation(r.store, r.logger))
+	appMux.HandleFunc("POST /app/organisation/membres/{id}/role", handler.PostMemberRole(r.store, r.logger))
+	appMux.HandleFunc("POST /app/organisation/membres/{id}/supprimer", handler.PostRemoveMember(r.store, r.logger))
+	appMux.HandleFunc("POST /app/organisation/quitter", handler.PostLeaveOrganization(r.store, r.logger))
+	appMux.HandleFunc("POST /app/invitations/accepter", handler.PostAcceptInvitation(r.store, r.logger))
+	appMux.HandleFunc("POST /impersonation/stop", handler.PostStopImpersonation(r.store, r.logger))
+	appHandler := handler.Use(handler.LogRoute("", appMux), handler.UserMiddleware(r.store, r.logger)...)
+	mux.Handle("/app", appHandler)
+	mux.Handle("/app/", appHandler)
+	mux.Handle("/impersonation/", appHandler)
+
+	// Followed from emails, possibly in a browser without a session
+	mux.HandleFunc("GET /email/confirmer", handler.GetEmailConfirm)
+	mux.HandleFunc("POST /email/confirmer", handler.PostEmailConfirm(r.store, r.logger, r.mailer))
+	mux.HandleFunc("GET /invitations/accepter", handler.GetInvitation(r.store, r.logger))
+}
+
+// setupAPI serves the JSON API under /api/v1. It reuses the handlers of
+// the HTML routes, which answer in JSON there. Every route is described in
+// the OpenAPI document: run go generate after changing them.
+func (r *router) setupAPI(api *openapi.Router) {
+	public := handler.PublicAPIMiddleware()
+	signedIn := handler.APIMiddleware(r.store, r.logger)
+	adminSession := handler.AdminAPIMiddleware(r.store, r.logger)
+	session, token := []string{"sessionCookie"}, []string{"sessionCookie", "bearerToken"}
+
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/auth/register",
+		Tag:      "auth",
+		Summary:  "Create an account and sign in",
+		Request:  handler.RegisterRequest{},
+		Status:   http.StatusCreated,
+		Response: handler.UserResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+	}, handler.Use(handler.RegisterUser(r.store, r.logger), public...))
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/auth/login",
+		Tag:      "auth",
+		Summary:  "Sign in with email and password",
+		Request:  handler.LoginRequest{},
+		Response: handler.UserResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, handler.Use(handler.PostLogin(r.store, r.logger), public...))
+	api.Handle(openapi.Route{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/auth/logout",
+		Tag:     "auth",
+		Summary: "Sign out of the current session",
+		Status:  http.StatusNoContent,
+	}, handler.Use(handler.PostLogout(r.store, r.logger), public...))
+	api.Handle(openapi.Route{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/auth/magic-link",
+		Tag:     "auth",
+		Summary: "Email a sign-in link",
+		Request: handler.MagicLinkRequest{},
+		Status:  http.StatusAccepted,
+		Errors:  []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	}, handler.Use(handler.PostMagicLink(r.store, r.logger, r.mailer, r.appURL), public...))
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/auth/magic-link/confirm",
+		Tag:      "auth",
+		Summary:  "Sign in with the token of an emailed link",
+		Request:  handler.MagicLinkConfirmRequest{},
+		Response: handler.UserResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+	}, handler.Use(handler.PostMagicLinkConfirm(r.store, r.logger), public...))
+
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/admin/login",
+		Tag:      "admin",
+		Summary:  "Sign in to the admin console and receive a verification code",
+		Request:  handler.LoginRequest{},
+		Status:   http.StatusAccepted,
+		Response: handler.AdminLoginResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, handler.Use(handler.PostAdminLogin(r.store, r.logger, r.mailer), public...))
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/admin/verify",
+		Tag:      "admin",
+		Security: session,
+		Summary:  "Verify the emailed admin code",
+		Request:  handler.VerifyOTPRequest{},
+		Status:   http.StatusNoContent,
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	}, handler.Use(handler.PostVerifyOTP(r.store, r.logger), adminSession...))
+	api.Handle(openapi.Route{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/admin/verify/resend",
+		Tag:      "admin",
+		Security: session,
+		Summary:  "Send a new admin code",
+		Status:   http.StatusNoContent,
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests},
+	}, handler.Use(handler.PostResendOTP(r.store, r.logger, r.mailer), adminSession...))
+
+	api.Handle(openapi.Route{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/me",
+		Tag:      "account",
+		Security: token,
+		Scope:    service.ScopeAccountRead,
+		Summary:  "Describe the signed-in user",
+		Response: handler.MeResponse{},
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+	}, handler.Use(http.HandlerFunc(handler.GetAPIMe), append(signedIn, handler.RequireScope(service.ScopeAccountRead))...))
+	api.Handle(openapi.Route{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/organizations",
+		Tag:      "organizations",
+		Security: token,
+		Scope:    service.ScopeOrgRead,
+		Summary:  "List the organizations of the signed-in user",
+		Response: handler.OrganizationsResponse{},
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+	}, handler.Use(http.HandlerFunc(handler.GetAPIOrganizations), append(signedIn, handler.RequireScope(service.ScopeOrgRead))...))
+}
+
+// openAPIDocument describes the routes of setupAPI.
+func openAPIDocument() ([]byte, error) {
+	api := openapi.NewRouter(http.NewServeMux())
+	newRouter(slog.Default(), nil, nil, nil, "", nil).setupAPI(api)
+
+	doc := openapi.Build(openapi.Spec{
+		Title:   "{{projectName}} API",
+		Version: "1",
+		SecuritySchemes: map[string]openapi.SecurityScheme{
+			"sessionCookie": {Type: "apiKey", In: "cookie", Name: "session", Description: "Session opened by signing in. Other methods than GET must send the X-CSRF-Token header, with the value of the X-CSRF-Token header of any response authenticated by the session."},
+			"bearerToken":   {Type: "http", Scheme: "bearer", Description: "Personal access token created in the user area."},
+		},
+		ErrorBody: handler.APIErrorResponse{},
+	}, api.Routes())
+	b, err := json.MarshalIndent(doc, "", "  ")
+	return append(b, '\n'), err
+}
+
+func writeOpenAPI(path string) error {
+	b, err := openAPIDocument()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// checkOpenAPI reports routes changed without running go generate.
+func checkOpenAPI() error {
+	b, err := openAPIDocument()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, web.OpenAPI) {
+		return errors.New("web/openapi.json does not match the API routes, run go generate")
+	}
+	return nil
 }
 
 func (r *router) setupAdmin(mux *http.ServeMux) {
 	privateMux := http.NewServeMux()
 	privateMux.HandleFunc("GET /verify", handler.GetVerifyOTP(r.store, r.logger))
-	privateMux.HandleFunc("POST /verify", handler.PostVerifyOTP(r.store))
-	privateMux.HandleFunc("GET /dashboard", handler.Dashboard)
-	privateHandler := handler.Use(privateMux, handler.AdminMiddleware(r.store, r.logger)...)
+	privateMux.HandleFunc("POST /verify", handler.PostVerifyOTP(r.store, r.logger))
+	privateMux.HandleFunc("POST /verify/resend", handler.PostResendOTP(r.store, r.logger, r.mailer))
+	privateMux.Handle("GET /dashboard", r.can("users:read", handler.Dashboard(r.store, r.logger)))
+	privateMux.Handle("GET /users/{id}", r.can("users:read", handler.GetUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/role", r.can("users:write", handler.PostUserRole(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/suspend", r.can("users:write", handler.PostSuspendUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/reactivate", r.can("users:write", handler.PostReactivateUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/reset-password", r.can("users:write", handler.PostResetUserPassword(r.store, r.logger, r.mailer, r.appURL)))
+	privateMux.Handle("POST /users/{id}/revoke-sessions", r.can("users:write", handler.PostRevokeUserSessions(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/delete", r.can("users:write", handler.PostDeleteUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/restore", r.can("users:write", handler.PostRestoreUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/erase", r.can("users:write", handler.PostEraseUser(r.store, r.logger)))
+	privateMux.Handle("POST /users/{id}/impersonate", r.can("users:impersonate", handler.PostImpersonate(r.store, r.logger)))
+	privateMux.Handle("GET /lockouts", r.can("lockouts:read", handler.GetLockouts(r.store, r.logger)))
+	privateMux.Handle("POST /lockouts/clear", r.can("lockouts:write", handler.PostClearLockout(r.store, r.logger)))
+	privateMux.Handle("GET /audit", r.can("audit:read", handler.GetAudit(r.store, r.logger)))
+	privateMux.Handle("GET /audit/export", r.can("audit:read", handler.GetAuditExport(r.store, r.logger)))
+	privateMux.Handle("GET /roles", r.can("roles:read", handler.GetRoles(r.store, r.logger)))
+	privateMux.Handle("POST /roles", r.can("roles:write", handler.PostRole(r.store, r.logger)))
+	privateMux.Handle("POST /roles/{role}/permissions", r.can("roles:write", handler.PostRolePermissions(r.store, r.logger)))
+	privateMux.Handle("POST /roles/{role}/delete", r.can("roles:write", handler.PostDeleteRole(r.store, r.logger)))
+	privateHandler := handler.Use(handler.LogRoute("/admin", privateMux), handler.AdminMiddleware(r.store, r.logger)...)
 	mux.Handle("/admin/", http.StripPrefix("/admin", privateHandler))
 }
+
+func (r *router) can(permission string, h http.Handler) http.Handler {
+	return handler.Use(h, handler.RequirePermission(permission))
+}
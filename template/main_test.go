@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"{{projectName}}/config"
+	"{{projectName}}/db"
+	"{{projectName}}/service"
+)
+
+// TestOpenAPIMatchesRoutes fails when the API routes changed without
+// running go generate.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	want, err := os.ReadFile("web/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := openAPIDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("web/openapi.json does not match the API routes, run go generate")
+	}
+}
+
+type openAPIDoc struct {
+	Paths map[string]map[string]struct {
+		Responses map[string]json.RawMessage `json:"responses"`
+	} `json:"paths"`
+}
+
+// TestOpenAPIOperationsAnswer sends each documented operation a request
+// without credentials nor fields, which every handler turns down before
+// reaching the database, and checks that the answer is one the document
+// lists, in JSON.
+func TestOpenAPIOperationsAnswer(t *testing.T) {
+	b, err := openAPIDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// The store has no connection: a handler reaching the database panics
+	// and answers 500, which no operation documents.
+	r := newRouter(logger, db.NewPostgresStore(nil), &config.GoogleOAuth{}, service.NewMailer("", ""), "http://localhost", nil)
+	h := r.route()
+
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			method := strings.ToUpper(method)
+			t.Run(method+" "+path, func(t *testing.T) {
+				var body io.Reader
+				if method != http.MethodGet {
+					body = strings.NewReader("{}")
+				}
+				req := httptest.NewRequest(method, path, body)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Accept", "application/json")
+				req.RemoteAddr = "192.0.2.1:1234"
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				documented := slices.Collect(maps.Keys(op.Responses))
+				if !slices.Contains(documented, strconv.Itoa(rec.Code)) {
+					t.Fatalf("status %d is not documented (%v): %s", rec.Code, documented, rec.Body)
+				}
+				if rec.Code != http.StatusNoContent && !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+					t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package openapi registers the JSON API routes together with their request
+// and response types, and builds the OpenAPI 3.1 document describing them.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route is a JSON API route. Request and Response are zero values of the
+// body types, nil when there is no body.
+type Route struct {
+	Method   string
+	Path     string
+	Summary  string
+	Tag      string
+	Security []string // security schemes accepted, none for public routes
+	Scope    string   // scope required from personal access tokens
+	Request  any
+	Status   int // success status, 200 when zero
+	Response any
+	Errors   []int // statuses answered with the error envelope
+}
+
+func (r Route) status() int {
+	if r.Status == 0 {
+		return http.StatusOK
+	}
+	return r.Status
+}
+
+// Router registers API routes on a ServeMux and remembers them for the
+// document, so that no route can be served without being described.
+type Router struct {
+	mux    *http.ServeMux
+	routes []Route
+}
+
+func NewRouter(mux *http.ServeMux) *Router {
+	return &Router{mux: mux}
+}
+
+func (rt *Router) Handle(route Route, h http.Handler) {
+	rt.mux.Handle(route.Method+" "+route.Path, h)
+	rt.routes = append(rt.routes, route)
+}
+
+func (rt *Router) Routes() []Route {
+	return rt.routes
+}
+
+// Spec holds what the document needs besides the routes.
+type Spec struct {
+	Title           string
+	Version         string
+	SecuritySchemes map[string]SecurityScheme
+	ErrorBody       any // body of every error response
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used by the document.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Build describes routes in an OpenAPI 3.1 document. Struct types become
+// shared schemas named after the Go type; a field is required unless its
+// JSON tag has omitempty.
+func Build(spec Spec, routes []Route) *Document {
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info:    Info{Title: spec.Title, Version: spec.Version},
+		Paths:   map[string]map[string]Operation{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: spec.SecuritySchemes,
+		},
+	}
+	schemas := doc.Components.Schemas
+
+	var errorSchema *Schema
+	if spec.ErrorBody != nil {
+		errorSchema = schemaOf(reflect.TypeOf(spec.ErrorBody), schemas)
+	}
+
+	for _, route := range routes {
+		op := Operation{
+			OperationID: operationID(route),
+			Summary:     route.Summary,
+			Security:    []map[string][]string{},
+			Responses:   map[string]Response{},
+		}
+		if route.Tag != "" {
+			op.Tags = []string{route.Tag}
+		}
+		for _, name := range route.Security {
+			var scopes []string
+			if route.Scope != "" && spec.SecuritySchemes[name].Scheme == "bearer" {
+				scopes = []string{route.Scope}
+			}
+			op.Security = append(op.Security, map[string][]string{name: append([]string{}, scopes...)})
+		}
+		if route.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: schemaOf(reflect.TypeOf(route.Request), schemas)}},
+			}
+		}
+
+		success := Response{Description: http.StatusText(route.status())}
+		if route.Response != nil {
+			success.Content = map[string]MediaType{"application/json": {Schema: schemaOf(reflect.TypeOf(route.Response), schemas)}}
+		}
+		op.Responses[strconv.Itoa(route.status())] = success
+		for _, status := range route.Errors {
+			op.Responses[strconv.Itoa(status)] = errorResponse(http.StatusText(status), errorSchema)
+		}
+		op.Responses["default"] = errorResponse("Unexpected error", errorSchema)
+
+		if doc.Paths[route.Path] == nil {
+			doc.Paths[route.Path] = map[string]Operation{}
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+func errorResponse(description string, schema *Schema) Response {
+	resp := Response{Description: description}
+	if schema != nil {
+		resp.Content = map[string]MediaType{"application/json": {Schema: schema}}
+	}
+	return resp
+}
+
+// operationID turns "POST /api/v1/auth/magic-link" into
+// "postAuthMagicLink".
+func operationID(route Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+	path := strings.TrimPrefix(route.Path, "/api/v1")
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '{' || r == '}' }) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOf(t reflect.Type, schemas map[string]*Schema) *Schema {
+	if t.Kind() == reflect.Pointer {
+		s := schemaOf(t.Elem(), schemas)
+		if typ, ok := s.Type.(string); ok {
+			s.Type = []string{typ, "null"}
+		}
+		return s
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		return structSchema(t, schemas)
+	}
+	return &Schema{}
+}
+
+// structSchema describes anonymous structs inline and named ones once in
+// the components.
+func structSchema(t reflect.Type, schemas map[string]*Schema) *Schema {
+	name := schemaName(t)
+	if name != "" {
+		if _, ok := schemas[name]; ok {
+			return &Schema{Ref: "#/components/schemas/" + name}
+		}
+		schemas[name] = nil // reserved while describing recursive types
+	}
+
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		field, opts, _ := strings.Cut(tag, ",")
+		if field == "" {
+			field = f.Name
+		}
+		s.Properties[field] = schemaOf(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, field)
+		}
+	}
+	sort.Strings(s.Required)
+
+	if name == "" {
+		return s
+	}
+	schemas[name] = s
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// schemaName turns the Go type loginRequest into "LoginRequest".
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
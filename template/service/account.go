@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/tracing"
+	"{{projectName}}/utils"
+)
+
+var (
+	ErrCurrentPasswordInvalid = errors.New("current password is incorrect")
+	ErrEmailUnchanged         = errors.New("new email is the current one")
+	ErrInvalidEmailChange     = errors.New("invalid or expired email confirmation link")
+	ErrConfirmationMismatch   = errors.New("confirmation does not match")
+)
+
+const emailChangeDuration = 24 * time.Hour
+
+func hashEmailChangeToken(token string) string {
+	return sign("email-change", token)
+}
+
+// checkCurrentPassword guards account changes. Failures count towards the
+// login lockout so the forms cannot be used to guess the password.
+func checkCurrentPassword(ctx context.Context, store db.AuthStore, u *db.User, password string) error {
+	if err := checkLockout(ctx, store, LockoutScopeLogin, u.Email); err != nil {
+		return err
+	}
+	full, err := store.GetUserByEmail(ctx, u.Email)
+	if err != nil {
+		return err
+	}
+	if _, err := CheckPassword(full.PasswordHash, password); err != nil {
+		if err := recordFailure(ctx, store, LockoutScopeLogin, u.Email); err != nil {
+			return err
+		}
+		return ErrCurrentPasswordInvalid
+	}
+	return nil
+}
+
+// ChangePassword sets a new password and signs the user out of every other
+// session. The current password is not asked for accounts without one, or
+// when an admin required a reset.
+func ChangePassword(ctx context.Context, store db.AuthStore, u *db.User, current, password, confirm, sessionToken string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ChangePassword")
+	defer tracing.End(span, &err)
+
+	if u.HasPassword && !u.MustChangePassword {
+		switch err := checkCurrentPassword(ctx, store, u, current); err {
+		case nil:
+		case ErrCurrentPasswordInvalid:
+			return FieldErrors{"current_password": err}
+		default:
+			return err
+		}
+	}
+	if fields := ValidateNewPassword(u.Email, password, confirm); len(fields) > 0 {
+		return fields
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return ErrPasswordHashFailed
+	}
+	if err := store.UpdatePasswordHash(ctx, u.ID, hash); err != nil {
+		return err
+	}
+	if u.MustChangePassword {
+		if err := store.SetMustChangePassword(ctx, u.ID, false); err != nil {
+			return err
+		}
+	}
+	if err := store.DeleteOtherSessions(ctx, u.ID, sessionToken); err != nil {
+		return err
+	}
+
+	recorder.Record(ctx, audit.Event{Action: audit.PasswordChanged, Target: u.ID})
+	return nil
+}
+
+// RequestEmailChange emails a confirmation link to the new address. The
+// account keeps its current email until the link is followed.
+func RequestEmailChange(ctx context.Context, store db.AuthStore, u *db.User, newEmail, password, appURL string, mailer *Mailer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RequestEmailChange")
+	defer tracing.End(span, &err)
+
+	switch {
+	case !ValidateEmail(newEmail):
+		return FieldErrors{"email": ErrInvalidEmailFormat}
+	case newEmail == u.Email:
+		return FieldErrors{"email": ErrEmailUnchanged}
+	}
+	if u.HasPassword {
+		switch err := checkCurrentPassword(ctx, store, u, password); err {
+		case nil:
+		case ErrCurrentPasswordInvalid:
+			return FieldErrors{"current_password": err}
+		default:
+			return err
+		}
+	}
+
+	switch err := checkEmailAvailable(ctx, store, newEmail); err {
+	case nil:
+	case ErrEmailAlreadyInUse:
+		return FieldErrors{"email": err}
+	default:
+		return err
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := store.CreateEmailChange(ctx, &db.EmailChange{
+		UserID:    u.ID,
+		NewEmail:  newEmail,
+		TokenHash: hashEmailChangeToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailChangeDuration),
+	}); err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(appURL, "/") + "/email/confirmer?token=" + url.QueryEscape(token)
+	text := fmt.Sprintf("Cliquez sur ce lien pour confirmer votre nouvelle adresse e-mail : %s\n\nIl expire dans %d heures.",
+		link, int(emailChangeDuration.Hours()))
+	if err := mailer.Send(ctx, newEmail, "Confirmez votre nouvelle adresse e-mail", text); err != nil {
+		return err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.EmailChangeRequested,
+		Target:   u.ID,
+		Metadata: map[string]string{"new_email": newEmail},
+	})
+	return nil
+}
+
+// ConfirmEmailChange applies the change behind token and tells the previous
+// address about it.
+func ConfirmEmailChange(ctx context.Context, store db.AuthStore, token string, mailer *Mailer) (_ *db.User, err error) {
+	ctx, span := tracing.Start(ctx, "service.ConfirmEmailChange")
+	defer tracing.End(span, &err)
+
+	if token == "" {
+		return nil, ErrInvalidEmailChange
+	}
+
+	change, err := store.ConsumeEmailChange(ctx, hashEmailChangeToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidEmailChange
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The address may have been taken since the request was made.
+	if err := checkEmailAvailable(ctx, store, change.NewEmail); err != nil {
+		return nil, err
+	}
+
+	u, err := store.GetUserByID(ctx, change.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.UpdateEmail(ctx, u.ID, change.NewEmail); err != nil {
+		return nil, err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.EmailChanged,
+		ActorID:  u.ID,
+		Target:   u.ID,
+		Metadata: map[string]string{"old_email": u.Email, "new_email": change.NewEmail},
+	})
+
+	text := fmt.Sprintf("L'adresse e-mail de votre compte a été remplacée par %s. Si vous n'êtes pas à l'origine de ce changement, contactez-nous.", change.NewEmail)
+	_ = mailer.Send(ctx, u.Email, "Votre adresse e-mail a été modifiée", text)
+
+	u.Email = change.NewEmail
+	return u, nil
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/tracing"
+)
+
+var (
+	ErrCannotModifySelf = errors.New("administrators cannot perform this action on their own account")
+	ErrUnknownRole      = errors.New("unknown role")
+	ErrUserNotDeleted   = errors.New("only deleted accounts can be erased")
+	// ErrPermissionEscalation is returned when an action would grant, or
+	// affect an account holding, permissions the administrator lacks.
+	ErrPermissionEscalation = errors.New("administrators cannot grant or act on permissions they do not hold")
+)
+
+const usersPageSize = 20
+
+// UserPage is one page of the admin user table.
+type UserPage struct {
+	Users  []*db.User
+	Search string
+	Status string
+	Page   int
+	Total  int
+}
+
+func (p UserPage) HasPrev() bool { return p.Page > 1 }
+func (p UserPage) HasNext() bool { return p.Page*usersPageSize < p.Total }
+func (p UserPage) PrevPage() int { return p.Page - 1 }
+func (p UserPage) NextPage() int { return p.Page + 1 }
+
+// ListUsers pages through the users whose email contains search. status
+// narrows the list to one account state; by default deleted accounts are
+// left out.
+func ListUsers(ctx context.Context, store db.UserStore, search, status string, page int) (_ UserPage, err error) {
+	ctx, span := tracing.Start(ctx, "service.ListUsers")
+	defer tracing.End(span, &err)
+
+	switch status {
+	case "", db.UserStatusActive, db.UserStatusSuspended, db.UserStatusDeleted:
+	default:
+		status = ""
+	}
+	page = max(page, 1)
+	users, total, err := store.SearchUsers(ctx, search, status, usersPageSize, (page-1)*usersPageSize)
+	if err != nil {
+		return UserPage{}, err
+	}
+	return UserPage{Users: users, Search: search, Status: status, Page: page, Total: total}, nil
+}
+
+// UserDetail is what the admin console shows about a single user.
+type UserDetail struct {
+	User     *db.User
+	Sessions []db.Session
+	Roles    []*db.Role
+}
+
+func GetUserDetail(ctx context.Context, store db.AuthStore, id string) (_ *UserDetail, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetUserDetail")
+	defer tracing.End(span, &err)
+
+	u, err := store.GetUserByIDIncludingDeleted(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := store.GetSessionsByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := store.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &UserDetail{User: u, Sessions: sessions, Roles: roles}, nil
+}
+
+// checkRolesWithin refuses roles granting a permission actor does not
+// hold, so that an account allowed to manage users cannot give itself, or
+// act on, more than it already has.
+func checkRolesWithin(ctx context.Context, store db.RoleStore, actor *db.User, roles ...string) error {
+	perms, err := UserPermissions(ctx, store, actor)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		names, err := store.GetRolePermissions(ctx, role)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !perms.Has(name) {
+				return ErrPermissionEscalation
+			}
+		}
+	}
+	return nil
+}
+
+// manageableUser returns the target of an admin action, after checking
+// that actor holds every permission of its role.
+func manageableUser(ctx context.Context, store db.AuthStore, actor *db.User, id string, includingDeleted bool) (*db.User, error) {
+	get := store.GetUserByID
+	if includingDeleted {
+		get = store.GetUserByIDIncludingDeleted
+	}
+	u, err := get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRolesWithin(ctx, store, actor, u.Role); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ChangeUserRole gives the user another role. Both the role the user has
+// and the one given must be within the actor's own permissions.
+func ChangeUserRole(ctx context.Context, store db.AuthStore, actor *db.User, id, role string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ChangeUserRole")
+	defer tracing.End(span, &err)
+
+	if actor.ID == id {
+		return ErrCannotModifySelf
+	}
+	roles, err := store.GetRoles(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(roles, func(r *db.Role) bool { return r.Name == role }) {
+		return ErrUnknownRole
+	}
+	if _, err := manageableUser(ctx, store, actor, id, false); err != nil {
+		return err
+	}
+	if err := checkRolesWithin(ctx, store, actor, role); err != nil {
+		return err
+	}
+	if err := store.UpdateUserRole(ctx, id, role); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminRoleChanged, actor, id, map[string]string{"role": role})
+	return nil
+}
+
+// SuspendUser blocks the account and signs it out everywhere; its data is
+// kept and ReactivateUser restores access.
+func SuspendUser(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.SuspendUser")
+	defer tracing.End(span, &err)
+
+	if actor.ID == id {
+		return ErrCannotModifySelf
+	}
+	if _, err := manageableUser(ctx, store, actor, id, false); err != nil {
+		return err
+	}
+	if err := store.SetUserStatus(ctx, id, db.UserStatusSuspended); err != nil {
+		return err
+	}
+	if err := store.DeleteByUserID(ctx, id); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminUserSuspended, actor, id, nil)
+	return nil
+}
+
+func ReactivateUser(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ReactivateUser")
+	defer tracing.End(span, &err)
+
+	u, err := manageableUser(ctx, store, actor, id, false)
+	if err != nil {
+		return err
+	}
+	if u.Status != db.UserStatusSuspended {
+		return nil
+	}
+	if err := store.SetUserStatus(ctx, id, db.UserStatusActive); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminUserReactivated, actor, id, nil)
+	return nil
+}
+
+// ForcePasswordReset emails a login link so a new password can be chosen,
+// then signs the user out and refuses further password logins. Nothing
+// changes when the link cannot be sent, or when one was sent less than
+// magicLinkCooldown ago, which fails with ErrMagicLinkTooSoon.
+func ForcePasswordReset(ctx context.Context, store db.AuthStore, actor *db.User, id, appURL string, mailer *Mailer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ForcePasswordReset")
+	defer tracing.End(span, &err)
+
+	u, err := manageableUser(ctx, store, actor, id, false)
+	if err != nil {
+		return err
+	}
+	if err := sendMagicLink(ctx, store, u, appURL, mailer); err != nil {
+		return err
+	}
+	if err := store.SetMustChangePassword(ctx, id, true); err != nil {
+		return err
+	}
+	if err := store.DeleteByUserID(ctx, id); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminPasswordReset, actor, id, nil)
+	return nil
+}
+
+func RevokeUserSessions(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RevokeUserSessions")
+	defer tracing.End(span, &err)
+
+	if _, err := manageableUser(ctx, store, actor, id, false); err != nil {
+		return err
+	}
+	if err := RevokeAllUserSessions(ctx, id, store); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminSessionsRevoked, actor, id, nil)
+	return nil
+}
+
+// DeleteUser soft-deletes the account: it disappears from the application
+// at once and is erased after ErasureGracePeriod unless RestoreUser is
+// called first. The only owner of an organization that has other members
+// cannot be deleted, see ErrLastOwner.
+func DeleteUser(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteUser")
+	defer tracing.End(span, &err)
+
+	if actor.ID == id {
+		return ErrCannotModifySelf
+	}
+	if _, err := manageableUser(ctx, store, actor, id, false); err != nil {
+		return err
+	}
+	if err := store.SetUserStatus(ctx, id, db.UserStatusDeleted); err != nil {
+		return err
+	}
+	at := time.Now().Add(ErasureGracePeriod)
+	if err := store.SetErasureScheduledAt(ctx, id, &at); err != nil {
+		return err
+	}
+	if err := store.DeleteByUserID(ctx, id); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminUserDeleted, actor, id, nil)
+	return nil
+}
+
+// RestoreUser brings back a soft-deleted account, unless its email has been
+// taken by a new account in the meantime.
+func RestoreUser(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RestoreUser")
+	defer tracing.End(span, &err)
+
+	u, err := manageableUser(ctx, store, actor, id, true)
+	if err != nil {
+		return err
+	}
+	if u.Status != db.UserStatusDeleted {
+		return nil
+	}
+	_, err = store.GetUserByEmail(ctx, u.Email)
+	switch {
+	case err == nil:
+		return ErrEmailAlreadyInUse
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	if err := store.SetUserStatus(ctx, id, db.UserStatusActive); err != nil {
+		return err
+	}
+	if err := store.SetErasureScheduledAt(ctx, id, nil); err != nil {
+		return err
+	}
+	recordAdmin(ctx, audit.AdminUserRestored, actor, id, nil)
+	return nil
+}
+
+// EraseUser erases a soft-deleted account without waiting for the end of
+// its grace period.
+func EraseUser(ctx context.Context, store db.AuthStore, actor *db.User, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.EraseUser")
+	defer tracing.End(span, &err)
+
+	if actor.ID == id {
+		return ErrCannotModifySelf
+	}
+	u, err := manageableUser(ctx, store, actor, id, true)
+	if err != nil {
+		return err
+	}
+	if u.Status != db.UserStatusDeleted {
+		return ErrUserNotDeleted
+	}
+	return eraseUser(ctx, store, u, audit.AdminUserErased, actor.ID)
+}
+
+func recordAdmin(ctx context.Context, action string, actor *db.User, target string, metadata map[string]string) {
+	recorder.Record(ctx, audit.Event{Action: action, ActorID: actor.ID, Target: target, Metadata: metadata})
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"{{projectName}}/db"
+)
+
+func newAdminStore() *memStore {
+	s := newMemStore()
+	s.roles[AdminRole] = []string{"admin:access", "users:read", "users:write", "roles:write"}
+	s.roles["support"] = []string{"admin:access", "users:read", "users:write"}
+	s.roles["user"] = nil
+	s.addUser("admin", "admin@example.com", AdminRole)
+	s.addUser("support", "support@example.com", "support")
+	s.addUser("other", "other@example.com", "user")
+	return s
+}
+
+func TestChangeUserRoleRefusesRolesBeyondTheActor(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   string
+		target  string
+		role    string
+		wantErr error
+	}{
+		{"support grants a lesser role", "support", "other", "user", nil},
+		{"support grants its own role", "support", "other", "support", nil},
+		{"support grants admin", "support", "other", AdminRole, ErrPermissionEscalation},
+		{"support demotes an admin", "support", "admin", "user", ErrPermissionEscalation},
+		{"admin grants admin", "admin", "other", AdminRole, nil},
+		{"admin changes itself", "admin", "admin", "user", ErrCannotModifySelf},
+		{"unknown role", "admin", "other", "owner", ErrUnknownRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminStore()
+			before := s.users[tt.target].Role
+			err := ChangeUserRole(context.Background(), s, s.users[tt.actor], tt.target, tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangeUserRole() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && s.users[tt.target].Role != tt.role {
+				t.Errorf("role = %q, want %q", s.users[tt.target].Role, tt.role)
+			}
+			if tt.wantErr != nil && s.users[tt.target].Role != before {
+				t.Errorf("role changed to %q despite the error", s.users[tt.target].Role)
+			}
+		})
+	}
+}
+
+func TestAdminActionsRefuseTargetsBeyondTheActor(t *testing.T) {
+	actions := map[string]func(ctx context.Context, s db.AuthStore, actor *db.User, id string) error{
+		"suspend":         SuspendUser,
+		"delete":          DeleteUser,
+		"revoke sessions": RevokeUserSessions,
+		"erase": func(ctx context.Context, s db.AuthStore, actor *db.User, id string) error {
+			s.(*memStore).users[id].Status = db.UserStatusDeleted
+			return EraseUser(ctx, s, actor, id)
+		},
+	}
+	for name, action := range actions {
+		t.Run(name, func(t *testing.T) {
+			s := newAdminStore()
+			if err := action(context.Background(), s, s.users["support"], "admin"); !errors.Is(err, ErrPermissionEscalation) {
+				t.Errorf("support on admin = %v, want %v", err, ErrPermissionEscalation)
+			}
+		})
+	}
+
+	s := newAdminStore()
+	if err := SuspendUser(context.Background(), s, s.users["support"], "other"); err != nil {
+		t.Fatalf("support suspends a user: %v", err)
+	}
+	if got := s.users["other"].Status; got != db.UserStatusSuspended {
+		t.Errorf("status = %q, want %q", got, db.UserStatusSuspended)
+	}
+}
+
+func TestForcePasswordResetChangesNothingWithoutALink(t *testing.T) {
+	tests := []struct {
+		name    string
+		recent  bool // a link was sent less than magicLinkCooldown ago
+		wantErr error
+	}{
+		{"mail not sent", false, ErrEmailSendFailed},
+		{"link sent recently", true, ErrMagicLinkTooSoon},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminStore()
+			if tt.recent {
+				s.magicLinks = []*db.MagicLink{{ID: "recent", UserID: "other", CreatedAt: time.Now()}}
+			}
+
+			// The mailer is not configured, so no link can be sent.
+			err := ForcePasswordReset(context.Background(), s, s.users["admin"], "other", "http://localhost", nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ForcePasswordReset() = %v, want %v", err, tt.wantErr)
+			}
+			if s.users["other"].MustChangePassword {
+				t.Error("the password must be changed although no link was sent")
+			}
+			if tt.recent != (len(s.magicLinks) == 1) {
+				t.Errorf("links = %v, want the unsent one deleted", s.magicLinks)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+)
+
+const auditPageSize = 50
+
+// recorder stores the audit trail of the service layer. It is nil, and
+// recording a no-op, until SetAuditRecorder is called at startup.
+var recorder *audit.Recorder
+
+func SetAuditRecorder(r *audit.Recorder) {
+	recorder = r
+}
+
+// AuditPage is one page of the admin audit log.
+type AuditPage struct {
+	Events []*db.AuditEvent
+	Filter db.AuditFilter
+	Page   int
+}
+
+func (p AuditPage) HasPrev() bool { return p.Page > 1 }
+func (p AuditPage) HasNext() bool { return len(p.Events) == auditPageSize }
+func (p AuditPage) PrevPage() int { return p.Page - 1 }
+func (p AuditPage) NextPage() int { return p.Page + 1 }
+
+func ListAuditEvents(ctx context.Context, store db.AuditStore, f db.AuditFilter, page int) (AuditPage, error) {
+	page = max(page, 1)
+	f.Limit, f.Offset = auditPageSize, (page-1)*auditPageSize
+	events, err := store.ListAuditEvents(ctx, f)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	return AuditPage{Events: events, Filter: f, Page: page}, nil
+}
+
+// ExportAuditEvents returns up to limit events matching f for a CSV export.
+func ExportAuditEvents(ctx context.Context, store db.AuditStore, f db.AuditFilter, limit int) ([]*db.AuditEvent, error) {
+	f.Limit, f.Offset = limit, 0
+	return store.ListAuditEvents(ctx, f)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
@@ -11,31 +12,43 @@ import (
 	"net"
 	"net/http"
 	"net/mail"
-	"strconv"
 	"strings"
 	"time"
+	"{{projectName}}/audit"
 	"{{projectName}}/db"
+	"{{projectName}}/metrics"
+	"{{projectName}}/tracing"
 	"{{projectName}}/utils"
-
-	"github.com/resend/resend-go/v2"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	ErrSessionExpired      = errors.New("session has expired")
-	ErrInvalidCredentials  = errors.New("invalid credentials")
-	ErrInvalidSession      = errors.New("invalid session")
-	ErrInvalidEmailFormat  = errors.New("invalid email format")
-	ErrPasswordTooWeak     = errors.New("password must be at least 8 characters")
-	ErrEmailAlreadyInUse   = errors.New("email already in use")
-	ErrPasswordHashFailed  = errors.New("failed to hash password")
-	ErrOTPGenerationFailed = errors.New("failed to generate OTP code")
-	ErrInvalidOTPCode      = errors.New("invalid OTP code")
-	ErrOTPExpired          = errors.New("OTP code has expired")
-	ErrEmailSendFailed     = errors.New("failed to send email")
+	ErrSessionExpired        = errors.New("session has expired")
+	ErrInvalidCredentials    = errors.New("invalid credentials")
+	ErrInvalidSession        = errors.New("invalid session")
+	ErrInvalidEmailFormat    = errors.New("invalid email format")
+	ErrPasswordTooWeak       = errors.New("password is too easy to guess")
+	ErrEmailAlreadyInUse     = errors.New("email already in use")
+	ErrPasswordHashFailed    = errors.New("failed to hash password")
+	ErrOTPGenerationFailed   = errors.New("failed to generate OTP code")
+	ErrInvalidOTPCode        = errors.New("invalid OTP code")
+	ErrOTPExpired            = errors.New("OTP code has expired")
+	ErrOTPTooManyAttempts    = errors.New("too many attempts for this OTP code")
+	ErrOTPResendTooSoon      = errors.New("an OTP code was sent recently")
+	ErrEmailSendFailed       = errors.New("failed to send email")
+	ErrAccountSuspended      = errors.New("account is suspended")
+	ErrPasswordResetRequired = errors.New("password must be reset before logging in")
 )
 
-const sessionDuration = 24 * time.Hour // Default session duration
+const (
+	sessionDuration   = 24 * time.Hour // Default session duration
+	minPasswordLength = 8
+	maxPasswordLength = 1024 // bounds the cost of hashing attacker-supplied input
+
+	otpLength         = 6
+	otpDuration       = 5 * time.Minute
+	otpResendCooldown = time.Minute
+	maxOTPAttempts    = 5
+)
 
 func GetIPAddressBytes(r *http.Request) []byte {
 	xForwardedFor := r.Header.Get("X-Forwarded-For")
@@ -65,8 +78,14 @@ func GenerateSessionToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func CheckPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// CheckPassword verifies password against a stored argon2id or bcrypt hash
+// and reports whether the hash should be upgraded.
+func CheckPassword(hashedPassword, password string) (rehash bool, err error) {
+	ok, rehash, err := utils.VerifyPassword(hashedPassword, password)
+	if err != nil || !ok {
+		return false, ErrInvalidCredentials
+	}
+	return rehash, nil
 }
 
 // ValidateEmail checks if the email format is valid
@@ -75,32 +94,55 @@ func ValidateEmail(email string) bool {
 	return err == nil
 }
 
-func IsValidPasswordLength(password string) bool {
-	length := len([]byte(password)) // counts bytes, not runes
-	return length >= 8 && length <= 72
-}
-
+// ValidateUserInput checks a registration: email format and the password
+// policy. The returned error is a FieldErrors keyed by form field.
 func ValidateUserInput(user db.User) error {
+	fields := FieldErrors{}
 	if !ValidateEmail(user.Email) {
-		return ErrInvalidEmailFormat
+		fields["email"] = ErrInvalidEmailFormat
+	}
+	if err := passwordPolicy.Check(user.PasswordHash, user.Email); err != nil {
+		fields["password"] = err
+	}
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// checkEmailAvailable returns ErrEmailAlreadyInUse when an account uses
+// email, including a soft-deleted one: its address is kept for it until it
+// is erased, so that it can be restored and its owner cannot come back
+// under a new account in the meantime.
+func checkEmailAvailable(ctx context.Context, store db.UserStore, email string) error {
+	if _, err := store.GetUserByEmail(ctx, email); err == nil {
+		return ErrEmailAlreadyInUse
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
-	if !IsValidPasswordLength(user.PasswordHash) {
-		return ErrPasswordTooWeak
+	if _, err := store.GetDeletedUserByEmail(ctx, email); err == nil {
+		return ErrEmailAlreadyInUse
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
 	return nil
 }
 
-func RegisterUser(ctx context.Context, store db.AuthStore, user db.User) (*db.User, error) {
+func RegisterUser(ctx context.Context, store db.AuthStore, user db.User) (_ *db.User, err error) {
+	ctx, span := tracing.Start(ctx, "service.RegisterUser")
+	defer tracing.End(span, &err)
+
 	if err := ValidateUserInput(user); err != nil {
 		return nil, err
 	}
 
-	_, err := store.GetUserByEmail(ctx, user.Email)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	if err := checkEmailAvailable(ctx, store, user.Email); err != nil {
 		return nil, err
 	}
 
+	_, hashSpan := tracing.Start(ctx, "password.Hash")
 	user.PasswordHash, err = utils.HashPassword(user.PasswordHash)
+	hashSpan.End()
 	if err != nil {
 		return nil, ErrPasswordHashFailed
 	}
@@ -108,24 +150,89 @@ func RegisterUser(ctx context.Context, store db.AuthStore, user db.User) (*db.Us
 	return store.CreateUser(ctx, &user)
 }
 
-func LoginUser(ctx context.Context, s db.Store, u db.User) (*db.User, error) {
-	if err := ValidateUserInput(u); err != nil {
+// LoginUser checks credentials. The password policy is deliberately not
+// applied here: it may have changed since the password was set.
+func LoginUser(ctx context.Context, s db.Store, u db.User) (_ *db.User, err error) {
+	ctx, span := tracing.Start(ctx, "service.LoginUser")
+	defer tracing.End(span, &err)
+
+	if !ValidateEmail(u.Email) {
+		return nil, ErrInvalidEmailFormat
+	}
+	if u.PasswordHash == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Failures are counted per email, whether or not the account exists, so
+	// the lockout does not reveal which emails are registered.
+	if err := checkLockout(ctx, s, LockoutScopeLogin, u.Email); err != nil {
+		loginFailed(ctx, u.Email, "locked")
 		return nil, err
 	}
 
 	existing, err := s.GetUserByEmail(ctx, u.Email)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			loginFailed(ctx, u.Email, "unknown_email")
+			if err := recordFailure(ctx, s, LockoutScopeLogin, u.Email); err != nil {
+				return nil, err
+			}
+		}
 		return nil, err
 	}
 
-	if err := CheckPassword(existing.PasswordHash, u.PasswordHash); err != nil {
+	_, verifySpan := tracing.Start(ctx, "password.Verify")
+	rehash, err := CheckPassword(existing.PasswordHash, u.PasswordHash)
+	verifySpan.End()
+	if err != nil {
+		loginFailed(ctx, u.Email, "bad_password")
+		if err := recordFailure(ctx, s, LockoutScopeLogin, u.Email); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
+	_ = s.ClearLockout(ctx, LockoutScopeLogin, u.Email)
 
+	if existing.Status != db.UserStatusActive {
+		loginFailed(ctx, u.Email, "suspended")
+		return nil, ErrAccountSuspended
+	}
+	if existing.MustChangePassword {
+		loginFailed(ctx, u.Email, "password_reset_required")
+		return nil, ErrPasswordResetRequired
+	}
+
+	// Transparently upgrade bcrypt hashes and argon2id hashes made with
+	// outdated parameters; a failure here must not block the login.
+	if rehash {
+		if hash, err := utils.HashPassword(u.PasswordHash); err == nil {
+			_ = s.UpdatePasswordHash(ctx, existing.ID, hash)
+		}
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.LoginSuccess,
+		ActorID:  existing.ID,
+		Target:   existing.Email,
+		Metadata: map[string]string{"method": "password"},
+	})
+	metrics.Logins.WithLabelValues("password", "success").Inc()
 	return existing, nil
 }
 
-func CreateSession(ctx context.Context, ss db.SessionStore, userID string, r *http.Request) (string, error) {
+func loginFailed(ctx context.Context, email, reason string) {
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.LoginFailure,
+		Target:   email,
+		Metadata: map[string]string{"reason": reason},
+	})
+	metrics.Logins.WithLabelValues("password", "failure").Inc()
+}
+
+func CreateSession(ctx context.Context, ss db.SessionStore, userID string, r *http.Request) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateSession")
+	defer tracing.End(span, &err)
+
 	// Generate secure session token
 	cookieHash, err := GenerateSessionToken()
 	if err != nil {
@@ -144,8 +251,13 @@ func CreateSession(ctx context.Context, ss db.SessionStore, userID string, r *ht
 	session.ExpiresAt = &expiresAt
 
 	// Create the session
+	token, err := ss.CreateSession(ctx, session)
+	if err != nil {
+		return "", err
+	}
 
-	return ss.CreateSession(ctx, session)
+	recorder.Record(ctx, audit.Event{Action: audit.SessionCreated, ActorID: userID, Target: userID})
+	return token, nil
 }
 
 // ValidateSession validates a session and checks for expiration
@@ -168,11 +280,16 @@ func ValidateSession(ctx context.Context, cookieHash string, ss db.SessionStore)
 // RefreshSession extends the session duration
 func RefreshSession(ctx context.Context, cookieHash string, ss db.SessionStore) error {
 	// Validate session first
-	_, err := ValidateSession(ctx, cookieHash, ss)
+	session, err := ValidateSession(ctx, cookieHash, ss)
 	if err != nil {
 		return err
 	}
 
+	// Impersonation sessions keep their initial, shorter lifetime
+	if session.ImpersonatorID != "" {
+		return nil
+	}
+
 	// Set new expiration time
 	newExpiryTime := time.Now().Add(sessionDuration)
 	return ss.UpdateExpiry(ctx, cookieHash, newExpiryTime)
@@ -180,17 +297,29 @@ func RefreshSession(ctx context.Context, cookieHash string, ss db.SessionStore)
 
 // RevokeSession invalidates a session
 func RevokeSession(ctx context.Context, cookieHash string, ss db.SessionStore) error {
-	return ss.DeleteByCookieHash(ctx, cookieHash)
+	if err := ss.DeleteByCookieHash(ctx, cookieHash); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{Action: audit.SessionRevoked})
+	return nil
 }
 
 // RevokeAllUserSessions invalidates all sessions for a given user
 func RevokeAllUserSessions(ctx context.Context, userID string, ss db.SessionStore) error {
-	return ss.DeleteByUserID(ctx, userID)
+	if err := ss.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.SessionRevoked,
+		Target:   userID,
+		Metadata: map[string]string{"scope": "all"},
+	})
+	return nil
 }
 
-func generateSecureOTP(length int) (int, error) {
+func generateSecureOTP(length int) (string, error) {
 	if length <= 0 || length > 9 {
-		return 0, errors.New("length should be between 0 to 9 include")
+		return "", errors.New("length should be between 0 to 9 include")
 	}
 
 	var otpChars strings.Builder
@@ -199,74 +328,124 @@ func generateSecureOTP(length int) (int, error) {
 	firstDigitLimit := big.NewInt(9)
 	firstDigit, err := rand.Int(rand.Reader, firstDigitLimit)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 	firstDigit.Add(firstDigit, big.NewInt(1))
 	otpChars.WriteString(firstDigit.String())
 
 	digitLimit := big.NewInt(10)
 	for i := 1; i < length; i++ {
-		digit, err2 := rand.Int(rand.Reader, digitLimit)
-		if err2 != nil {
-			return 0, err
+		digit, err := rand.Int(rand.Reader, digitLimit)
+		if err != nil {
+			return "", err
 		}
 		otpChars.WriteString(digit.String())
 	}
 
-	otpStr := otpChars.String()
-	toint, err := strconv.Atoi(otpStr)
-	if err != nil {
-		return 0, fmt.Errorf("échec de la conversion en uint32: %w", err)
-	}
+	return otpChars.String(), nil
+}
 
-	return toint, nil
+// hashOTP keys the code with the server secret: six digits are trivial to
+// brute force from a plain hash if the table leaks.
+func hashOTP(userId, code string) string {
+	return sign("otp", userId, code)
 }
 
-func CreateOTP(ctx context.Context, store db.OtpStore, id string, api string) error {
-	code, err := generateSecureOTP(6)
+// CreateOTP issues a new code for the user, invalidating earlier ones, and
+// emails it. Codes can be re-issued once per otpResendCooldown; a code that
+// could not be sent is invalidated at once, so it does not hold back the
+// next attempt.
+func CreateOTP(ctx context.Context, store db.OtpStore, id string, email string, mailer *Mailer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateOTP")
+	defer tracing.End(span, &err)
+
+	if last, err := store.GetActiveOtp(ctx, id); err == nil && time.Since(last.CreatedAt) < otpResendCooldown {
+		metrics.RateLimited.WithLabelValues("otp_resend").Inc()
+		return ErrOTPResendTooSoon
+	}
+
+	code, err := generateSecureOTP(otpLength)
 	if err != nil {
 		return ErrOTPGenerationFailed
 	}
-	if err := store.CreateOtp(ctx, &db.Otp{Code: code, UserId: id, CreatedAt: time.Now(), Used: false}); err != nil {
-		return err
+	now := time.Now()
+	otp := &db.Otp{
+		UserId:    id,
+		CodeHash:  hashOTP(id, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(otpDuration),
 	}
-
-	client := resend.NewClient(api)
-	params := &resend.SendEmailRequest{
-		From:    "",
-		To:      []string{""},
-		Subject: "",
-		Text:    fmt.Sprintf("your code is", code),
+	if err := store.CreateOtp(ctx, otp); err != nil {
+		return err
 	}
-
-	if _, err := client.Emails.Send(params); err != nil {
-		return ErrEmailSendFailed
+	if err := mailer.Send(ctx, email, "Your verification code", fmt.Sprintf("your code is %s", code)); err != nil {
+		_, markErr := store.MarkOtpAsUsed(ctx, otp.ID)
+		return errors.Join(err, markErr)
 	}
-
+	recorder.Record(ctx, audit.Event{Action: audit.OTPIssued, ActorID: id, Target: id})
+	metrics.OTPIssued.Inc()
 	return nil
 }
 
-func ValidateOTP(ctx context.Context, userId string, code string, store db.OtpStore) error {
-	intcode, err := strconv.Atoi(code)
-	if err != nil {
+func ValidateOTP(ctx context.Context, userId string, code string, store db.AuthStore) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ValidateOTP")
+	defer tracing.End(span, &err)
+
+	if err := checkLockout(ctx, store, LockoutScopeOTP, userId); err != nil {
+		otpFailed(ctx, userId, "locked")
 		return err
 	}
-	otp, err := store.GetOtp(ctx, userId, intcode)
-	if err != nil {
+
+	otp, err := store.GetActiveOtp(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		otpFailed(ctx, userId, "no_active_code")
 		return ErrInvalidOTPCode
 	}
+	if err != nil {
+		return err
+	}
 
-	if time.Since(otp.CreatedAt) > time.Duration(5*time.Minute) {
+	if time.Now().After(otp.ExpiresAt) {
+		otpFailed(ctx, userId, "expired")
 		return ErrOTPExpired
 	}
 
-	if otp.Used {
+	if otp.Attempts >= maxOTPAttempts {
+		otpFailed(ctx, userId, "too_many_attempts")
+		return ErrOTPTooManyAttempts
+	}
+
+	if !hmac.Equal([]byte(otp.CodeHash), []byte(hashOTP(userId, code))) {
+		otpFailed(ctx, userId, "wrong_code")
+		if err := store.IncrementOtpAttempts(ctx, otp.ID); err != nil {
+			return err
+		}
+		if err := recordFailure(ctx, store, LockoutScopeOTP, userId); err != nil {
+			return err
+		}
 		return ErrInvalidOTPCode
 	}
 
-	if err := store.MarkOtpAsUsed(ctx, userId, intcode); err != nil {
+	ok, err := store.MarkOtpAsUsed(ctx, otp.ID)
+	if err != nil {
 		return fmt.Errorf("failed to mark OTP as used: %w", err)
 	}
+	if !ok {
+		return ErrInvalidOTPCode
+	}
+	_ = store.ClearLockout(ctx, LockoutScopeOTP, userId)
 
+	recorder.Record(ctx, audit.Event{Action: audit.OTPVerified, ActorID: userId, Target: userId})
+	metrics.OTPVerifications.WithLabelValues("success").Inc()
 	return nil
 }
+
+func otpFailed(ctx context.Context, userId, reason string) {
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OTPFailed,
+		ActorID:  userId,
+		Target:   userId,
+		Metadata: map[string]string{"reason": reason},
+	})
+	metrics.OTPVerifications.WithLabelValues("failure").Inc()
+}
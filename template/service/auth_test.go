@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"{{projectName}}/db"
+)
+
+func TestCreateOTPCooldownOnlyAfterDelivery(t *testing.T) {
+	s := newMemStore()
+	ctx := context.Background()
+
+	// The mailer is not configured, so the code cannot be sent.
+	for range 2 {
+		if err := CreateOTP(ctx, s, "user", "user@example.com", nil); !errors.Is(err, ErrEmailSendFailed) {
+			t.Fatalf("CreateOTP() = %v, want %v", err, ErrEmailSendFailed)
+		}
+	}
+	if _, err := s.GetActiveOtp(ctx, "user"); err == nil {
+		t.Error("a code that was not sent is still active")
+	}
+
+	s.otps = append(s.otps, &db.Otp{ID: "sent", UserId: "user", CreatedAt: time.Now()})
+	if err := CreateOTP(ctx, s, "user", "user@example.com", nil); !errors.Is(err, ErrOTPResendTooSoon) {
+		t.Errorf("CreateOTP() after a delivered code = %v, want %v", err, ErrOTPResendTooSoon)
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/tracing"
+)
+
+var (
+	ErrCannotImpersonate = errors.New("accounts with admin access cannot be impersonated")
+	ErrNotImpersonating  = errors.New("session is not an impersonation session")
+	ErrImpersonating     = errors.New("action not allowed while impersonating a user")
+)
+
+// impersonationDuration is shorter than sessionDuration and is not extended
+// by RefreshSession.
+const impersonationDuration = time.Hour
+
+// StartImpersonation opens a session as the user id on behalf of actor and
+// returns its token. The admin's own session is left untouched so it can be
+// restored by StopImpersonation.
+func StartImpersonation(ctx context.Context, store db.AuthStore, actor *db.User, id string, r *http.Request) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "service.StartImpersonation")
+	defer tracing.End(span, &err)
+
+	if actor.ID == id {
+		return "", ErrCannotModifySelf
+	}
+	u, err := store.GetUserByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if u.Status != db.UserStatusActive {
+		return "", ErrAccountSuspended
+	}
+	// Impersonating another admin would hand out permissions the actor
+	// may not have.
+	perms, err := UserPermissions(ctx, store, u)
+	if err != nil {
+		return "", err
+	}
+	if perms.Has("admin:access") {
+		return "", ErrCannotImpersonate
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(impersonationDuration)
+	if _, err := store.CreateSession(ctx, db.Session{
+		UserID:         u.ID,
+		Token:          token,
+		ExpiresAt:      &expiresAt,
+		IPAddress:      GetIPAddressBytes(r),
+		UserAgent:      r.UserAgent(),
+		ImpersonatorID: actor.ID,
+	}); err != nil {
+		return "", err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.ImpersonationStarted,
+		ActorID:  actor.ID,
+		Target:   u.ID,
+		Metadata: map[string]string{"email": u.Email},
+	})
+	return token, nil
+}
+
+// StopImpersonation ends the impersonation session token and reports
+// whether adminToken is still a valid session of the admin who opened it.
+func StopImpersonation(ctx context.Context, ss db.SessionStore, token, adminToken string) (_ db.Session, _ bool, err error) {
+	ctx, span := tracing.Start(ctx, "service.StopImpersonation")
+	defer tracing.End(span, &err)
+
+	s, err := ss.GetByCookieHash(ctx, token)
+	if err != nil {
+		return db.Session{}, false, err
+	}
+	if s.ImpersonatorID == "" {
+		return db.Session{}, false, ErrNotImpersonating
+	}
+	if err := ss.DeleteByCookieHash(ctx, token); err != nil {
+		return db.Session{}, false, err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:  audit.ImpersonationStopped,
+		ActorID: s.ImpersonatorID,
+		Target:  s.UserID,
+	})
+
+	if adminToken == "" {
+		return s, false, nil
+	}
+	admin, err := ValidateSession(ctx, adminToken, ss)
+	return s, err == nil && admin.UserID == s.ImpersonatorID && admin.ImpersonatorID == "", nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"{{projectName}}/db"
+)
+
+// RunJanitor purges expired OTP codes, login links, email changes,
+// invitations, API tokens and sessions, and erases the accounts whose
+// erasure is due, every interval until ctx is cancelled.
+func RunJanitor(ctx context.Context, store db.Store, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeExpired(ctx, store, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpired(ctx context.Context, store db.Store, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	otps, err := store.DeleteExpiredOtps(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired otps", slog.String("error", err.Error()))
+	}
+
+	links, err := store.DeleteExpiredMagicLinks(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired magic links", slog.String("error", err.Error()))
+	}
+
+	emailChanges, err := store.DeleteExpiredEmailChanges(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired email changes", slog.String("error", err.Error()))
+	}
+
+	invitations, err := store.DeleteExpiredInvitations(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired invitations", slog.String("error", err.Error()))
+	}
+
+	apiTokens, err := store.DeleteExpiredAPITokens(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired api tokens", slog.String("error", err.Error()))
+	}
+
+	sessions, err := store.DeleteExpiredSessions(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to purge expired sessions", slog.String("error", err.Error()))
+	}
+
+	erased := eraseDueAccounts(ctx, store, logger)
+
+	if otps > 0 || links > 0 || emailChanges > 0 || invitations > 0 || apiTokens > 0 || sessions > 0 || erased > 0 {
+		logger.InfoContext(ctx, "purged expired data",
+			slog.Int64("otps", otps),
+			slog.Int64("magic_links", links),
+			slog.Int64("email_changes", emailChanges),
+			slog.Int64("invitations", invitations),
+			slog.Int64("api_tokens", apiTokens),
+			slog.Int64("sessions", sessions),
+			slog.Int("erased_accounts", erased))
+	}
+}
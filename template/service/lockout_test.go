@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"{{projectName}}/db"
+	"{{projectName}}/utils"
+)
+
+func TestLockoutPolicyLockDuration(t *testing.T) {
+	login := lockoutPolicies[LockoutScopeLogin]
+	otp := lockoutPolicies[LockoutScopeOTP]
+	tests := []struct {
+		name     string
+		policy   db.LockoutPolicy
+		failures int
+		want     time.Duration
+	}{
+		{"login first failure", login, 1, 0},
+		{"login below threshold", login, 4, 0},
+		{"login threshold", login, 5, 30 * time.Second},
+		{"login doubles", login, 6, time.Minute},
+		{"login doubles again", login, 7, 2 * time.Minute},
+		{"login below max", login, 11, 32 * time.Minute},
+		{"login capped", login, 12, time.Hour},
+		{"login stays capped", login, 1000, time.Hour},
+		{"otp below threshold", otp, 2, 0},
+		{"otp threshold", otp, 3, time.Minute},
+		{"otp doubles", otp, 4, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.LockDuration(tt.failures); got != tt.want {
+				t.Errorf("LockDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	defer utils.SetPasswordHasher(utils.NewPasswordHasher(utils.DefaultArgon2Params))
+	utils.SetPasswordHasher(utils.NewPasswordHasher(utils.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}))
+
+	s := newMemStore()
+	u := s.addUser("user", "user@example.com", "user")
+	hash, err := utils.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash = hash
+
+	ctx := context.Background()
+	login := func(email, password string) error {
+		_, err := LoginUser(ctx, s, db.User{Email: email, PasswordHash: password})
+		return err
+	}
+	lockedFor := func(email string) time.Duration {
+		l, ok := s.lockouts[LockoutScopeLogin+" "+email]
+		if !ok || l.LockedUntil == nil {
+			return 0
+		}
+		return time.Until(*l.LockedUntil).Round(time.Second)
+	}
+	unlock := func(email string) {
+		past := time.Now().Add(-time.Second)
+		s.lockouts[LockoutScopeLogin+" "+email].LockedUntil = &past
+	}
+
+	// A success before the threshold starts the count over.
+	for range 4 {
+		if err := login(u.Email, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("login with a wrong password = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	if err := login(u.Email, "secret"); err != nil {
+		t.Fatalf("login below the threshold = %v, want nil", err)
+	}
+	if _, ok := s.lockouts[LockoutScopeLogin+" "+u.Email]; ok {
+		t.Fatal("a successful login left the failures counted")
+	}
+
+	for range 5 {
+		if err := login(u.Email, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("login with a wrong password = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	if err := login(u.Email, "secret"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("login once locked = %v, want %v", err, ErrAccountLocked)
+	}
+	if got := lockedFor(u.Email); got != 30*time.Second {
+		t.Errorf("locked for %v, want 30s", got)
+	}
+
+	// Each failure after the lock expires locks for twice as long.
+	unlock(u.Email)
+	if err := login(u.Email, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("login with a wrong password = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if got := lockedFor(u.Email); got != time.Minute {
+		t.Errorf("locked for %v, want 1m0s", got)
+	}
+
+	unlock(u.Email)
+	if err := login(u.Email, "secret"); err != nil {
+		t.Fatalf("login once the lock expired = %v, want nil", err)
+	}
+
+	// Unknown emails are locked alike, so the lockout reveals nothing.
+	for range 5 {
+		if err := login("nobody@example.com", "wrong"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("login with an unknown email = %v, want %v", err, sql.ErrNoRows)
+		}
+	}
+	if err := login("nobody@example.com", "wrong"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("login with a locked unknown email = %v, want %v", err, ErrAccountLocked)
+	}
+}
+
+func TestLockoutFailuresExpire(t *testing.T) {
+	s := newMemStore()
+	ctx := context.Background()
+	for range 4 {
+		if err := recordFailure(ctx, s, LockoutScopeLogin, "user@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := s.lockouts[LockoutScopeLogin+" user@example.com"]
+	l.LastFailureAt = time.Now().Add(-lockoutPolicies[LockoutScopeLogin].Reset - time.Minute)
+
+	if err := recordFailure(ctx, s, LockoutScopeLogin, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLockout(ctx, s, LockoutScopeLogin, "user@example.com"); err != nil {
+		t.Errorf("checkLockout() = %v after failures older than the reset period", err)
+	}
+}
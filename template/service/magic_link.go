@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/metrics"
+	"{{projectName}}/tracing"
+)
+
+var (
+	ErrInvalidMagicLink = errors.New("invalid or expired login link")
+	ErrMagicLinkTooSoon = errors.New("a login link was sent recently")
+)
+
+const (
+	magicLinkDuration = 15 * time.Minute
+	magicLinkCooldown = time.Minute
+)
+
+func hashMagicLinkToken(token string) string {
+	return sign("magic-link", token)
+}
+
+// RequestMagicLink emails a single-use login link to email. Unknown emails
+// are silently ignored so the form does not reveal which accounts exist;
+// for the same reason a mailer that is not configured fails every request,
+// before the account is looked up.
+func RequestMagicLink(ctx context.Context, store db.AuthStore, email, appURL string, mailer *Mailer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RequestMagicLink")
+	defer tracing.End(span, &err)
+
+	if !ValidateEmail(email) {
+		return ErrInvalidEmailFormat
+	}
+	if !mailer.Configured() {
+		return fmt.Errorf("%w: mailer not configured", ErrEmailSendFailed)
+	}
+
+	user, err := store.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = sendMagicLink(ctx, store, user, appURL, mailer)
+	if errors.Is(err, ErrMagicLinkTooSoon) {
+		// The link sent recently is still valid; answer as for any email.
+		return nil
+	}
+	return err
+}
+
+// sendMagicLink emails a new login link to u, once per magicLinkCooldown.
+// A link that could not be sent is deleted, so that it does not hold back
+// the next attempt.
+func sendMagicLink(ctx context.Context, store db.MagicLinkStore, u *db.User, appURL string, mailer *Mailer) error {
+	if last, err := store.GetLatestMagicLink(ctx, u.ID); err == nil && time.Since(last.CreatedAt) < magicLinkCooldown {
+		return ErrMagicLinkTooSoon
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	l := &db.MagicLink{
+		UserID:    u.ID,
+		TokenHash: hashMagicLinkToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(magicLinkDuration),
+	}
+	if err := store.CreateMagicLink(ctx, l); err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(appURL, "/") + "/connexion/lien/verifier?token=" + url.QueryEscape(token)
+	text := fmt.Sprintf("Cliquez sur ce lien pour vous connecter : %s\n\nIl expire dans %d minutes et ne peut servir qu'une fois.",
+		link, int(magicLinkDuration.Minutes()))
+	if err := mailer.Send(ctx, u.Email, "Votre lien de connexion", text); err != nil {
+		return errors.Join(err, store.DeleteMagicLink(ctx, l.ID))
+	}
+	return nil
+}
+
+// ConsumeMagicLink exchanges a link token for its user. A token works once.
+func ConsumeMagicLink(ctx context.Context, store db.AuthStore, token string) (_ *db.User, err error) {
+	ctx, span := tracing.Start(ctx, "service.ConsumeMagicLink")
+	defer tracing.End(span, &err)
+
+	if token == "" {
+		metrics.Logins.WithLabelValues("magic_link", "failure").Inc()
+		return nil, ErrInvalidMagicLink
+	}
+
+	userID, err := store.ConsumeMagicLink(ctx, hashMagicLinkToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		metrics.Logins.WithLabelValues("magic_link", "failure").Inc()
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != db.UserStatusActive {
+		metrics.Logins.WithLabelValues("magic_link", "failure").Inc()
+		return nil, ErrAccountSuspended
+	}
+	// Following the link proves the user receives the mail of the address.
+	if !u.EmailVerified {
+		if err := store.MarkEmailVerified(ctx, u.ID); err != nil {
+			return nil, err
+		}
+		u.EmailVerified = true
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.LoginSuccess,
+		ActorID:  u.ID,
+		Target:   u.Email,
+		Metadata: map[string]string{"method": "magic_link"},
+	})
+	metrics.Logins.WithLabelValues("magic_link", "success").Inc()
+	return u, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"{{projectName}}/tracing"
+
+	"github.com/resend/resend-go/v2"
+)
+
+// Mailer sends transactional emails through Resend.
+type Mailer struct {
+	client *resend.Client
+	from   string
+}
+
+func NewMailer(apiKey, from string) *Mailer {
+	m := &Mailer{from: from}
+	if apiKey != "" {
+		m.client = resend.NewCustomClient(tracing.HTTPClient, apiKey)
+	}
+	return m
+}
+
+// Configured reports whether the mailer has an API key and a sender.
+func (m *Mailer) Configured() bool {
+	return m != nil && m.client != nil && m.from != ""
+}
+
+func (m *Mailer) Send(ctx context.Context, to, subject, text string) (err error) {
+	ctx, span := tracing.Start(ctx, "mail.Send")
+	defer tracing.End(span, &err)
+
+	if !m.Configured() {
+		return ErrEmailSendFailed
+	}
+	params := &resend.SendEmailRequest{
+		From:    m.from,
+		To:      []string{to},
+		Subject: subject,
+		Text:    text,
+	}
+	if _, err := m.client.Emails.SendWithContext(ctx, params); err != nil {
+		return ErrEmailSendFailed
+	}
+	return nil
+}
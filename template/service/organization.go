@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/tracing"
+)
+
+var (
+	ErrInvalidOrgName          = errors.New("organization name must be 2-64 characters")
+	ErrInvalidOrgRole          = errors.New("unknown organization role")
+	ErrNotOrgMember            = errors.New("not a member of this organization")
+	ErrOrgForbidden            = errors.New("your organization role does not allow this")
+	ErrLastOwner               = db.ErrLastOwner
+	ErrInvalidInvitation       = errors.New("invalid or expired invitation")
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to another email")
+	ErrEmailNotVerified        = errors.New("email address not verified")
+)
+
+const invitationDuration = 7 * 24 * time.Hour
+
+func hashInvitationToken(token string) string {
+	return sign("invitation", token)
+}
+
+// CanManageOrg reports whether the membership allows inviting and managing
+// members.
+func CanManageOrg(m *db.Membership) bool {
+	return m != nil && (m.Role == db.OrgRoleOwner || m.Role == db.OrgRoleAdmin)
+}
+
+func CreateOrganization(ctx context.Context, store db.OrganizationStore, u *db.User, name string) (_ *db.Organization, err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateOrganization")
+	defer tracing.End(span, &err)
+
+	name = strings.TrimSpace(name)
+	if n := utf8.RuneCountInString(name); n < 2 || n > 64 {
+		return nil, ErrInvalidOrgName
+	}
+	o := &db.Organization{Name: name}
+	if err := store.CreateOrganization(ctx, o, u.ID); err != nil {
+		return nil, err
+	}
+	recorder.Record(ctx, audit.Event{Action: audit.OrgCreated, Target: o.ID, Metadata: map[string]string{"name": name}})
+	return o, nil
+}
+
+// CurrentMembership picks the membership matching orgID among the user's
+// memberships, falling back to the first one. It returns nil for a user who
+// belongs to no organization.
+func CurrentMembership(memberships []*db.Membership, orgID string) *db.Membership {
+	for _, m := range memberships {
+		if m.OrgID == orgID {
+			return m
+		}
+	}
+	if len(memberships) > 0 {
+		return memberships[0]
+	}
+	return nil
+}
+
+// The functions below act on the member's current organization and expect
+// ctx to be scoped to it with db.WithTenant.
+
+// OrgPage is what a member sees about their current organization.
+type OrgPage struct {
+	Membership  *db.Membership
+	Members     []*db.Membership
+	Invitations []*db.Invitation
+}
+
+func GetOrgPage(ctx context.Context, store db.AuthStore, m *db.Membership) (_ *OrgPage, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetOrgPage")
+	defer tracing.End(span, &err)
+
+	members, err := store.GetMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	page := &OrgPage{Membership: m, Members: members}
+	if CanManageOrg(m) {
+		if page.Invitations, err = store.GetPendingInvitations(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// InviteMember emails an invitation to join the actor's organization.
+func InviteMember(ctx context.Context, store db.AuthStore, actor *db.Membership, email, role, appURL string, mailer *Mailer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.InviteMember")
+	defer tracing.End(span, &err)
+
+	if !CanManageOrg(actor) {
+		return ErrOrgForbidden
+	}
+	if !ValidateEmail(email) {
+		return FieldErrors{"email": ErrInvalidEmailFormat}
+	}
+	if role != db.OrgRoleAdmin && role != db.OrgRoleMember {
+		return ErrInvalidOrgRole
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := store.CreateInvitation(ctx, &db.Invitation{
+		Email:     email,
+		Role:      role,
+		TokenHash: hashInvitationToken(token),
+		InvitedBy: actor.UserID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationDuration),
+	}); err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(appURL, "/") + "/invitations/accepter?token=" + url.QueryEscape(token)
+	text := fmt.Sprintf("%s vous invite à rejoindre %s. Suivez ce lien pour accepter : %s\n\nL'invitation expire dans %d jours.",
+		actor.Email, actor.OrgName, link, int(invitationDuration.Hours()/24))
+	if err := mailer.Send(ctx, email, "Invitation à rejoindre "+actor.OrgName, text); err != nil {
+		return err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OrgMemberInvited,
+		Target:   actor.OrgID,
+		Metadata: map[string]string{"email": email, "role": role},
+	})
+	return nil
+}
+
+func RevokeInvitation(ctx context.Context, store db.InvitationStore, actor *db.Membership, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RevokeInvitation")
+	defer tracing.End(span, &err)
+
+	if !CanManageOrg(actor) {
+		return ErrOrgForbidden
+	}
+	if err := store.DeleteInvitation(ctx, id); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OrgInvitationRevoked,
+		Target:   actor.OrgID,
+		Metadata: map[string]string{"invitation_id": id},
+	})
+	return nil
+}
+
+// GetInvitation looks up a pending invitation from its emailed token.
+func GetInvitation(ctx context.Context, store db.InvitationStore, token string) (_ *db.Invitation, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetInvitation")
+	defer tracing.End(span, &err)
+
+	if token == "" {
+		return nil, ErrInvalidInvitation
+	}
+	i, err := store.GetInvitationByTokenHash(ctx, hashInvitationToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidInvitation
+	}
+	return i, err
+}
+
+// AcceptInvitation makes u a member of the organization behind token. The
+// invitation only works for the email it was sent to, once u has proved
+// they receive its mail.
+func AcceptInvitation(ctx context.Context, store db.InvitationStore, u *db.User, token string) (_ *db.Invitation, err error) {
+	ctx, span := tracing.Start(ctx, "service.AcceptInvitation")
+	defer tracing.End(span, &err)
+
+	i, err := GetInvitation(ctx, store, token)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(i.Email, u.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+	if !u.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	if err := store.AcceptInvitation(ctx, i.ID, u.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OrgMemberJoined,
+		Target:   i.OrgID,
+		Metadata: map[string]string{"role": i.Role},
+	})
+	return i, nil
+}
+
+// ChangeMemberRole is reserved to owners, who may also hand over ownership.
+// The last owner cannot step down.
+func ChangeMemberRole(ctx context.Context, store db.AuthStore, actor *db.Membership, userID, role string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ChangeMemberRole")
+	defer tracing.End(span, &err)
+
+	if actor.Role != db.OrgRoleOwner {
+		return ErrOrgForbidden
+	}
+	switch role {
+	case db.OrgRoleOwner, db.OrgRoleAdmin, db.OrgRoleMember:
+	default:
+		return ErrInvalidOrgRole
+	}
+	_, err = store.GetMembership(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotOrgMember
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.UpdateMembershipRole(ctx, userID, role); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OrgMemberRoleChanged,
+		Target:   actor.OrgID,
+		Metadata: map[string]string{"user_id": userID, "role": role},
+	})
+	return nil
+}
+
+// RemoveMember lets owners remove anyone and admins remove members.
+func RemoveMember(ctx context.Context, store db.AuthStore, actor *db.Membership, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RemoveMember")
+	defer tracing.End(span, &err)
+
+	if !CanManageOrg(actor) {
+		return ErrOrgForbidden
+	}
+	m, err := store.GetMembership(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotOrgMember
+	}
+	if err != nil {
+		return err
+	}
+	if actor.Role == db.OrgRoleAdmin && m.Role != db.OrgRoleMember {
+		return ErrOrgForbidden
+	}
+	if err := store.DeleteMembership(ctx, userID); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.OrgMemberRemoved,
+		Target:   actor.OrgID,
+		Metadata: map[string]string{"user_id": userID},
+	})
+	return nil
+}
+
+func LeaveOrganization(ctx context.Context, store db.AuthStore, m *db.Membership) (err error) {
+	ctx, span := tracing.Start(ctx, "service.LeaveOrganization")
+	defer tracing.End(span, &err)
+
+	if err := store.DeleteMembership(ctx, m.UserID); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{Action: audit.OrgMemberLeft, Target: m.OrgID})
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"{{projectName}}/db"
+)
+
+func TestOwnersLeavingKeepAnOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		other   func(s *memStore)
+		wantErr error
+	}{
+		{"another owner", func(s *memStore) {}, nil},
+		{"another owner deleted", func(s *memStore) { s.users["other"].Status = db.UserStatusDeleted }, ErrLastOwner},
+		{"another owner due for erasure", func(s *memStore) {
+			at := time.Now().Add(ErasureGracePeriod)
+			s.users["other"].ErasureScheduledAt = &at
+		}, ErrLastOwner},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminStore()
+			s.addUser("owner", "owner@example.com", "user")
+			s.addMembership("owner", "org", db.OrgRoleOwner)
+			s.addMembership("other", "org", db.OrgRoleOwner)
+			s.addMembership("support", "org", db.OrgRoleMember)
+			tt.other(s)
+
+			ctx := db.WithTenant(context.Background(), "org")
+			err := LeaveOrganization(ctx, s, &db.Membership{UserID: "owner", OrgID: "org", Role: db.OrgRoleOwner})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("LeaveOrganization() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteUserKeepsAnOwner(t *testing.T) {
+	s := newAdminStore()
+	s.addMembership("other", "org", db.OrgRoleOwner)
+	s.addMembership("support", "org", db.OrgRoleMember)
+
+	err := DeleteUser(context.Background(), s, s.users["admin"], "other")
+	if !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("DeleteUser() = %v, want %v", err, ErrLastOwner)
+	}
+	if s.users["other"].Status != db.UserStatusActive {
+		t.Errorf("status = %q despite the error", s.users["other"].Status)
+	}
+
+	s.addMembership("admin", "org", db.OrgRoleOwner)
+	if err := DeleteUser(context.Background(), s, s.users["admin"], "other"); err != nil {
+		t.Fatalf("DeleteUser() with another owner = %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrPasswordTooShort         = errors.New("password is too short")
+	ErrPasswordTooLong          = errors.New("password is too long")
+	ErrPasswordMismatch         = errors.New("passwords do not match")
+	ErrPasswordContainsPersonal = errors.New("password contains personal or project information")
+	ErrPasswordBreached         = errors.New("password appears in a known data breach")
+)
+
+const projectName = "{{projectName}}"
+
+// breached-passwords.txt uses the Have I Been Pwned format: one uppercase
+// SHA-1 per line, optionally followed by ":count".
+//
+//go:embed breached-passwords.txt
+var bundledBreachedPasswords string
+
+// commonFragments are words and keyboard walks that zxcvbn would find in its
+// dictionaries; a password built from them is cheap to guess.
+var commonFragments = []string{
+	"password", "passwd", "motdepasse", "admin", "welcome", "letmein", "login",
+	"qwerty", "azerty", "qwertyuiop", "azertyuiop", "asdfghjkl", "qsdfghjklm",
+	"zxcvbnm", "wxcvbn", "iloveyou", "jetaime", "bonjour", "soleil", "monkey",
+	"dragon", "football", "baseball", "master", "shadow", "sunshine", "princess",
+	"secret", "summer", "winter", "hello", "love", "test", "user",
+}
+
+// FieldErrors maps form field names to the validation error of that field.
+type FieldErrors map[string]error
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field, err := range fe {
+		fields = append(fields, field+": "+err.Error())
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, "; ")
+}
+
+// PasswordPolicy decides whether a new password is acceptable. Score is on
+// the zxcvbn scale: 0 (too guessable) to 4 (very unguessable).
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	MinScore  int
+	Banned    []string
+	breached  map[[sha1.Size]byte]struct{}
+}
+
+func NewPasswordPolicy(minLength, minScore int, banned ...string) *PasswordPolicy {
+	p := &PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: maxPasswordLength,
+		MinScore:  minScore,
+		Banned:    append([]string{projectName}, banned...),
+		breached:  make(map[[sha1.Size]byte]struct{}),
+	}
+	_ = p.LoadBreachedList(strings.NewReader(bundledBreachedPasswords))
+	return p
+}
+
+// LoadBreachedList adds SHA-1 hashes in HIBP format to the breached set.
+// Lines that are not valid hashes are skipped.
+func (p *PasswordPolicy) LoadBreachedList(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i != -1 {
+			line = line[:i]
+		}
+		var sum [sha1.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(line)); err != nil || n != sha1.Size {
+			continue
+		}
+		p.breached[sum] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (p *PasswordPolicy) LoadBreachedFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.LoadBreachedList(f)
+}
+
+func (p *PasswordPolicy) IsBreached(password string) bool {
+	_, ok := p.breached[sha1.Sum([]byte(password))]
+	return ok
+}
+
+// Check validates password for the account identified by email. Only the
+// first failing rule is reported so the form shows one message at a time.
+func (p *PasswordPolicy) Check(password, email string) error {
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > p.MaxLength {
+		return ErrPasswordTooLong
+	}
+
+	personal := append(emailTokens(email), p.Banned...)
+	lower := strings.ToLower(password)
+	for _, token := range personal {
+		if len(token) >= 3 && strings.Contains(lower, strings.ToLower(token)) {
+			return ErrPasswordContainsPersonal
+		}
+	}
+
+	if p.IsBreached(password) {
+		return ErrPasswordBreached
+	}
+
+	if PasswordScore(password, personal...) < p.MinScore {
+		return ErrPasswordTooWeak
+	}
+	return nil
+}
+
+func emailTokens(email string) []string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if local == "" {
+		return nil
+	}
+	tokens := []string{local}
+	for _, part := range strings.FieldsFunc(local, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == '+'
+	}) {
+		if len(part) >= 4 && part != local {
+			tokens = append(tokens, part)
+		}
+	}
+	return tokens
+}
+
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+// PasswordScore estimates how guessable a password is, in the spirit of
+// zxcvbn: dictionary words, keyboard walks, sequences, repeats and the
+// user's own inputs count as a single cheap token, every other character is
+// charged at the size of its character class. The estimated number of
+// guesses is mapped to zxcvbn's 0-4 scale.
+func PasswordScore(password string, userInputs ...string) int {
+	// leet only rewrites single ASCII characters, so both slices line up
+	plain := []rune(strings.ToLower(password))
+	unleet := []rune(leet.Replace(string(plain)))
+	fragments := append(append([]string{}, commonFragments...), userInputs...)
+
+	var guesses float64 // log10
+	for i := 0; i < len(plain); {
+		n := weakTokenLength(plain[i:], unleet[i:], fragments)
+		if n >= 3 {
+			// a handful of guesses per dictionary word or pattern
+			guesses += 2 + math.Log10(float64(n))
+			i += n
+			continue
+		}
+		guesses += math.Log10(float64(charsetSize(plain[i])))
+		i++
+	}
+
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// weakTokenLength returns the length of the longest weak pattern at the
+// start of s: a known fragment (also looked up in its de-leeted form), a
+// repeated character or an ascending or descending sequence.
+func weakTokenLength(s, unleet []rune, fragments []string) int {
+	best := 0
+	for _, f := range fragments {
+		f = strings.ToLower(f)
+		n := len([]rune(f))
+		if n > best && (strings.HasPrefix(string(s), f) || strings.HasPrefix(string(unleet), f)) {
+			best = n
+		}
+	}
+
+	repeat := 1
+	for repeat < len(s) && s[repeat] == s[0] {
+		repeat++
+	}
+	best = max(best, repeat)
+
+	for _, step := range []rune{1, -1} {
+		seq := 1
+		for seq < len(s) && s[seq]-s[seq-1] == step {
+			seq++
+		}
+		best = max(best, seq)
+	}
+	return best
+}
+
+// charsetSize is the brute-force pool of the character class r belongs to.
+func charsetSize(r rune) int {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return 26
+	case r >= '0' && r <= '9':
+		return 10
+	case r < unicode.MaxASCII:
+		return 33
+	default:
+		return 100
+	}
+}
+
+var passwordPolicy = NewPasswordPolicy(minPasswordLength, 2)
+
+// SetPasswordPolicy replaces the policy applied to new passwords. It is meant
+// to be called once at startup.
+func SetPasswordPolicy(p *PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// ValidateNewPassword applies the password policy to a password being set on
+// registration or password change.
+func ValidateNewPassword(email, password, confirm string) FieldErrors {
+	fields := FieldErrors{}
+	if err := passwordPolicy.Check(password, email); err != nil {
+		fields["password"] = err
+	} else if password != confirm {
+		fields["confirm_password"] = ErrPasswordMismatch
+	}
+	return fields
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	p := NewPasswordPolicy(8, 2, "acme")
+	breached := sha1.Sum([]byte("Tomato-Cactus-91"))
+	if err := p.LoadBreachedList(strings.NewReader("not a hash\n" + strings.ToUpper(hex.EncodeToString(breached[:])) + ":12\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{"strong", "k8#Vq2!zR9m$", nil},
+		{"passphrase", "correct horse battery staple", nil},
+		{"too short", "k8#Vq2!", ErrPasswordTooShort},
+		{"short in bytes, long enough in characters", "éèàùçœæ€", nil},
+		{"too long", strings.Repeat("k8#Vq2!zR9m$", maxPasswordLength/12+1), ErrPasswordTooLong},
+		{"email local part", "jean.dupont92", ErrPasswordContainsPersonal},
+		{"part of the email", "x-Dupont-8!z", ErrPasswordContainsPersonal},
+		{"banned word", "k8#AcMe2!zR9", ErrPasswordContainsPersonal},
+		{"bundled breached list", "qwertyuiop", ErrPasswordBreached},
+		{"loaded breached list", "Tomato-Cactus-91", ErrPasswordBreached},
+		{"keyboard walk", "azertyuiop1", ErrPasswordTooWeak},
+		{"repeat", "aaaaaaaaaaa", ErrPasswordTooWeak},
+		{"leet word", "p@ssw0rd!", ErrPasswordTooWeak},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Check(tt.password, "jean.dupont@example.com"); !errors.Is(err, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordScore(t *testing.T) {
+	tests := []struct {
+		password string
+		inputs   []string
+		want     int
+	}{
+		{"aaaaaaaa", nil, 0},
+		{"abcdefgh", nil, 0},
+		{"87654321", nil, 0},
+		{"p@ssw0rd", nil, 0},
+		{"qwertyuiop", nil, 1},
+		{"P@ssw0rd2024", nil, 2},
+		{"k8#Vq2!zR9m$", nil, 4},
+		{"correct horse battery staple", nil, 4},
+		{"dupont", []string{"dupont"}, 0},
+	}
+	for _, tt := range tests {
+		if got := PasswordScore(tt.password, tt.inputs...); got != tt.want {
+			t.Errorf("PasswordScore(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNewPassword(t *testing.T) {
+	tests := []struct {
+		name              string
+		password, confirm string
+		field             string
+		want              error
+	}{
+		{"valid", "k8#Vq2!zR9m$", "k8#Vq2!zR9m$", "", nil},
+		{"mismatch", "k8#Vq2!zR9m$", "k8#Vq2!zR9m", "confirm_password", ErrPasswordMismatch},
+		{"policy first", "short", "other", "password", ErrPasswordTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := ValidateNewPassword("jean.dupont@example.com", tt.password, tt.confirm)
+			if tt.want == nil {
+				if len(fields) != 0 {
+					t.Errorf("ValidateNewPassword() = %v, want none", fields)
+				}
+				return
+			}
+			if len(fields) != 1 || !errors.Is(fields[tt.field], tt.want) {
+				t.Errorf("ValidateNewPassword() = %v, want %s: %v", fields, tt.field, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+	"{{projectName}}/tracing"
+)
+
+// ErasureGracePeriod is how long a user can change their mind after asking
+// for their account to be erased.
+const ErasureGracePeriod = 30 * 24 * time.Hour
+
+// exportLimit caps the audit events included in a data export.
+const exportLimit = 10000
+
+type exportedUser struct {
+	ID                 string     `json:"id"`
+	Email              string     `json:"email"`
+	Role               string     `json:"role"`
+	Verified           bool       `json:"verified"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	Status             string     `json:"status"`
+	SuspendedAt        *time.Time `json:"suspended_at,omitempty"`
+	ErasureScheduledAt *time.Time `json:"erasure_scheduled_at,omitempty"`
+}
+
+type exportedIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+type exportedSession struct {
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	IPAddress    string     `json:"ip_address"`
+	UserAgent    string     `json:"user_agent"`
+	Impersonated bool       `json:"impersonated"`
+}
+
+type exportedAPIToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type exportedEvent struct {
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ExportUserData writes a ZIP archive of the personal data held about u:
+// account, sign-in identities, sessions, API tokens and audit events.
+// Secrets such as password hashes and tokens are left out.
+func ExportUserData(ctx context.Context, store db.AuthStore, u *db.User, w io.Writer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ExportUserData")
+	defer tracing.End(span, &err)
+
+	u, err = store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	sessions, err := store.GetSessionsByUserID(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	tokens, err := store.GetUserAPITokens(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	events, err := store.ListAuditEvents(ctx, db.AuditFilter{UserID: u.ID, Limit: exportLimit})
+	if err != nil {
+		return err
+	}
+
+	identities := []exportedIdentity{}
+	if u.HasPassword {
+		identities = append(identities, exportedIdentity{Provider: "password", Subject: u.Email})
+	}
+	if u.GoogleID != "" {
+		identities = append(identities, exportedIdentity{Provider: "google", Subject: u.GoogleID})
+	}
+
+	exportedSessions := make([]exportedSession, 0, len(sessions))
+	for _, s := range sessions {
+		exportedSessions = append(exportedSessions, exportedSession{
+			CreatedAt:    s.CreatedAt,
+			ExpiresAt:    s.ExpiresAt,
+			IPAddress:    s.IPAddress.String(),
+			UserAgent:    s.UserAgent,
+			Impersonated: s.ImpersonatorID != "",
+		})
+	}
+
+	exportedTokens := make([]exportedAPIToken, 0, len(tokens))
+	for _, t := range tokens {
+		exportedTokens = append(exportedTokens, exportedAPIToken{
+			Name:       t.Name,
+			Scopes:     t.Scopes,
+			CreatedAt:  t.CreatedAt,
+			ExpiresAt:  t.ExpiresAt,
+			LastUsedAt: t.LastUsedAt,
+		})
+	}
+
+	exportedEvents := make([]exportedEvent, 0, len(events))
+	for _, e := range events {
+		exportedEvents = append(exportedEvents, exportedEvent{
+			Action:    e.Action,
+			Target:    e.Target,
+			IPAddress: e.IPAddress,
+			UserAgent: e.UserAgent,
+			Metadata:  e.Metadata,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"account.json", exportedUser{
+			ID:                 u.ID,
+			Email:              u.Email,
+			Role:               u.Role,
+			Verified:           u.Verify,
+			CreatedAt:          u.CreatedAt,
+			UpdatedAt:          u.UpdatedAt,
+			Status:             u.Status,
+			SuspendedAt:        u.SuspendedAt,
+			ErasureScheduledAt: u.ErasureScheduledAt,
+		}},
+		{"identities.json", identities},
+		{"sessions.json", exportedSessions},
+		{"api_tokens.json", exportedTokens},
+		{"audit_events.json", exportedEvents},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	recorder.Record(ctx, audit.Event{Action: audit.DataExported, Target: u.ID})
+	return nil
+}
+
+// ScheduleErasure asks for the user's own account to be erased once
+// ErasureGracePeriod has passed. Accounts with a password confirm with it,
+// the others by typing their email. Other sessions are signed out. The
+// only owner of an organization that has other members gets ErrLastOwner
+// and must hand over ownership first.
+func ScheduleErasure(ctx context.Context, store db.AuthStore, u *db.User, password, confirmEmail, sessionToken string, mailer *Mailer) (_ time.Time, err error) {
+	ctx, span := tracing.Start(ctx, "service.ScheduleErasure")
+	defer tracing.End(span, &err)
+
+	if u.HasPassword {
+		switch err := checkCurrentPassword(ctx, store, u, password); err {
+		case nil:
+		case ErrCurrentPasswordInvalid:
+			return time.Time{}, FieldErrors{"delete_password": err}
+		default:
+			return time.Time{}, err
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(confirmEmail), u.Email) {
+		return time.Time{}, FieldErrors{"confirm_email": ErrConfirmationMismatch}
+	}
+
+	at := time.Now().Add(ErasureGracePeriod)
+	if err := store.SetErasureScheduledAt(ctx, u.ID, &at); err != nil {
+		return time.Time{}, err
+	}
+	if err := store.DeleteOtherSessions(ctx, u.ID, sessionToken); err != nil {
+		return time.Time{}, err
+	}
+
+	recorder.Record(ctx, audit.Event{
+		Action:   audit.ErasureScheduled,
+		Target:   u.ID,
+		Metadata: map[string]string{"erase_at": at.Format(time.RFC3339)},
+	})
+
+	text := fmt.Sprintf("Votre compte et vos données seront supprimés le %s. Connectez-vous avant cette date pour annuler la suppression.",
+		at.Format("02/01/2006"))
+	_ = mailer.Send(ctx, u.Email, "Suppression de votre compte", text)
+	return at, nil
+}
+
+func CancelErasure(ctx context.Context, store db.AuthStore, u *db.User) (err error) {
+	ctx, span := tracing.Start(ctx, "service.CancelErasure")
+	defer tracing.End(span, &err)
+
+	if err := store.SetErasureScheduledAt(ctx, u.ID, nil); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{Action: audit.ErasureCancelled, Target: u.ID})
+	return nil
+}
+
+// erasurePseudonym stands for an erased user in the audit log. It is stable
+// so the events of one user can still be told apart from another's.
+func erasurePseudonym(userID string) string {
+	return "erased:" + sign("erasure", userID)[:16]
+}
+
+// eraseUser deletes u and anonymises the audit log, under every address
+// the account has had. It fails with ErrLastOwner while u is the only
+// owner of an organization that has other members. The event is recorded
+// once the erasure is done, already under the pseudonym.
+func eraseUser(ctx context.Context, store db.AuthStore, u *db.User, action string, actorID string) error {
+	emails, err := userEmails(ctx, store, u)
+	if err != nil {
+		return err
+	}
+	pseudonym := erasurePseudonym(u.ID)
+	if err := store.EraseUser(ctx, u, emails, pseudonym); err != nil {
+		return err
+	}
+	recorder.Record(ctx, audit.Event{Action: action, ActorID: actorID, Target: pseudonym})
+	return nil
+}
+
+// userEmails lists the current address of u and those found in its email
+// change events, confirmed or not, but the ones another account now uses.
+func userEmails(ctx context.Context, store db.AuthStore, u *db.User) ([]string, error) {
+	events, err := store.ListAuditEvents(ctx, db.AuditFilter{Action: "account.email_change", Target: u.ID, Limit: exportLimit})
+	if err != nil {
+		return nil, err
+	}
+
+	emails := []string{u.Email}
+	for _, e := range events {
+		for _, key := range []string{"old_email", "new_email"} {
+			email := e.Metadata[key]
+			if email == "" || slices.Contains(emails, email) {
+				continue
+			}
+			other, err := store.GetUserByEmail(ctx, email)
+			switch {
+			case err == nil && other.ID != u.ID:
+				continue
+			case err != nil && !errors.Is(err, sql.ErrNoRows):
+				return nil, err
+			}
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+// eraseDueAccounts erases the accounts whose grace period is over.
+func eraseDueAccounts(ctx context.Context, store db.Store, logger *slog.Logger) int {
+	users, err := store.GetUsersDueForErasure(ctx, time.Now())
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to list accounts due for erasure", slog.String("error", err.Error()))
+		return 0
+	}
+
+	erased := 0
+	for _, u := range users {
+		if err := eraseUser(ctx, store, u, audit.AccountErased, ""); err != nil {
+			logger.ErrorContext(ctx, "unable to erase account", slog.String("user_id", u.ID), slog.String("error", err.Error()))
+			continue
+		}
+		erased++
+	}
+	return erased
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+)
+
+func TestEraseUserAnonymisesPreviousEmails(t *testing.T) {
+	s := newAdminStore()
+	u := s.addUser("changed", "new@example.com", "user")
+	u.Status = db.UserStatusDeleted
+	s.addUser("taken", "taken@example.com", "user")
+	s.events = []*db.AuditEvent{
+		{Action: audit.EmailChangeRequested, Target: u.ID, Metadata: map[string]string{"new_email": "new@example.com"}},
+		{Action: audit.EmailChanged, Target: u.ID, Metadata: map[string]string{"old_email": "old@example.com", "new_email": "new@example.com"}},
+		{Action: audit.EmailChangeRequested, Target: u.ID, Metadata: map[string]string{"new_email": "typo@example.com"}},
+		{Action: audit.EmailChangeRequested, Target: u.ID, Metadata: map[string]string{"new_email": "taken@example.com"}},
+		{Action: audit.EmailChanged, Target: "other", Metadata: map[string]string{"old_email": "someone@example.com"}},
+	}
+
+	if err := EraseUser(context.Background(), s, s.users["admin"], u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.erased[u.ID]
+	slices.Sort(got)
+	want := []string{"new@example.com", "old@example.com", "typo@example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("anonymised emails = %v, want %v", got, want)
+	}
+	if _, ok := s.users[u.ID]; ok {
+		t.Error("the user was not deleted")
+	}
+}
+
+func TestEraseUserDeletesInvitationsToItsEmails(t *testing.T) {
+	s := newAdminStore()
+	u := s.addUser("changed", "new@example.com", "user")
+	u.Status = db.UserStatusDeleted
+	s.events = []*db.AuditEvent{
+		{Action: audit.EmailChanged, Target: u.ID, Metadata: map[string]string{"old_email": "old@example.com", "new_email": "new@example.com"}},
+	}
+	s.invitations = []*db.Invitation{
+		{ID: "current", OrgID: "org", Email: "new@example.com"},
+		{ID: "previous", OrgID: "org", Email: "old@example.com"},
+		{ID: "someone", OrgID: "org", Email: "someone@example.com"},
+	}
+
+	if err := EraseUser(context.Background(), s, s.users["admin"], u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.invitations) != 1 || s.invitations[0].ID != "someone" {
+		t.Errorf("invitations left = %v, want only someone's", s.invitations)
+	}
+}
+
+func TestErasureKeepsAnOwner(t *testing.T) {
+	tests := []struct {
+		name     string
+		orgs     func(s *memStore)
+		wantErr  error
+		wantOrgs []string // organizations left with members
+	}{
+		{"member", func(s *memStore) {
+			s.addMembership("other", "shared", db.OrgRoleOwner)
+			s.addMembership("leaving", "shared", db.OrgRoleMember)
+		}, nil, []string{"shared"}},
+		{"owner with another owner", func(s *memStore) {
+			s.addMembership("leaving", "shared", db.OrgRoleOwner)
+			s.addMembership("other", "shared", db.OrgRoleOwner)
+		}, nil, []string{"shared"}},
+		{"only owner with members", func(s *memStore) {
+			s.addMembership("leaving", "shared", db.OrgRoleOwner)
+			s.addMembership("other", "shared", db.OrgRoleAdmin)
+		}, ErrLastOwner, []string{"shared"}},
+		{"only member", func(s *memStore) {
+			s.addMembership("leaving", "solo", db.OrgRoleOwner)
+			s.invitations = []*db.Invitation{{ID: "pending", OrgID: "solo", Email: "invited@example.com"}}
+		}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminStore()
+			u := s.addUser("leaving", "leaving@example.com", "user")
+			tt.orgs(s)
+
+			_, err := ScheduleErasure(context.Background(), s, u, "", u.Email, "session", nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ScheduleErasure() = %v, want %v", err, tt.wantErr)
+			}
+			if scheduled := s.users[u.ID].ErasureScheduledAt != nil; scheduled != (tt.wantErr == nil) {
+				t.Errorf("erasure scheduled = %v despite ScheduleErasure() = %v", scheduled, err)
+			}
+
+			s.users[u.ID].Status = db.UserStatusDeleted
+			err = EraseUser(context.Background(), s, s.users["admin"], u.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EraseUser() = %v, want %v", err, tt.wantErr)
+			}
+			if _, kept := s.users[u.ID]; kept != (tt.wantErr != nil) {
+				t.Errorf("user kept = %v despite EraseUser() = %v", kept, err)
+			}
+
+			var orgs []string
+			for _, m := range s.memberships {
+				if m.UserID != u.ID && !slices.Contains(orgs, m.OrgID) {
+					orgs = append(orgs, m.OrgID)
+				}
+			}
+			if !slices.Equal(orgs, tt.wantOrgs) {
+				t.Errorf("organizations left = %v, want %v", orgs, tt.wantOrgs)
+			}
+			if tt.wantOrgs == nil && len(s.invitations) > 0 {
+				t.Errorf("invitations left = %v, want those of the deleted organization gone", s.invitations)
+			}
+		})
+	}
+}
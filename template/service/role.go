@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"{{projectName}}/audit"
+	"{{projectName}}/db"
+)
+
+var (
+	ErrInvalidRoleName  = errors.New("role name must be 2-32 lowercase letters, digits, '-' or '_'")
+	ErrRoleNotEditable  = errors.New("the admin role always has every permission")
+	ErrRoleNotDeletable = errors.New("builtin roles and roles assigned to users cannot be deleted")
+)
+
+// AdminRole is granted every permission and cannot be edited, so there is
+// always a way back into the admin area.
+const AdminRole = "admin"
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+// Permissions is the set of permission names granted to a user.
+type Permissions map[string]struct{}
+
+func (p Permissions) Has(permission string) bool {
+	_, ok := p[permission]
+	return ok
+}
+
+// UserPermissions loads the permissions granted by the user's role.
+func UserPermissions(ctx context.Context, store db.RoleStore, u *db.User) (Permissions, error) {
+	names, err := store.GetRolePermissions(ctx, u.Role)
+	if err != nil {
+		return nil, err
+	}
+	perms := make(Permissions, len(names))
+	for _, name := range names {
+		perms[name] = struct{}{}
+	}
+	return perms, nil
+}
+
+func GetRoles(ctx context.Context, store db.RoleStore) ([]*db.Role, []*db.Permission, error) {
+	roles, err := store.GetRoles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	permissions, err := store.GetPermissions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return roles, permissions, nil
+}
+
+func CreateRole(ctx context.Context, store db.RoleStore, name, description string, permissions []string) error {
+	if !roleNamePattern.MatchString(name) {
+		return ErrInvalidRoleName
+	}
+	if err := store.CreateRole(ctx, &db.Role{Name: name, Description: description, Permissions: permissions}); err != nil {
+		return err
+	}
+	recordRole(ctx, audit.AdminRoleCreated, name, permissions)
+	return nil
+}
+
+func SetRolePermissions(ctx context.Context, store db.RoleStore, role string, permissions []string) error {
+	if role == AdminRole {
+		return ErrRoleNotEditable
+	}
+	if err := store.SetRolePermissions(ctx, role, permissions); err != nil {
+		return err
+	}
+	recordRole(ctx, audit.AdminRoleUpdated, role, permissions)
+	return nil
+}
+
+func DeleteRole(ctx context.Context, store db.RoleStore, role string) error {
+	ok, err := store.DeleteRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRoleNotDeletable
+	}
+	recorder.Record(ctx, audit.Event{Action: audit.AdminRoleDeleted, Target: role})
+	return nil
+}
+
+// recordRole logs a role change; the actor comes from the request context.
+func recordRole(ctx context.Context, action, role string, permissions []string) {
+	recorder.Record(ctx, audit.Event{
+		Action:   action,
+		Target:   role,
+		Metadata: map[string]string{"permissions": strings.Join(permissions, ",")},
+	})
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// secretKey signs and keys server-issued secrets. It defaults to a random
+// key, which only works for a single instance and does not survive restarts.
+var secretKey = func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+// SetSecretKey sets the key shared by every instance of the application. It
+// is meant to be called once at startup.
+func SetSecretKey(key string) {
+	if key != "" {
+		secretKey = []byte(key)
+	}
+}
+
+// sign returns the hex HMAC-SHA256 of parts joined by NUL bytes.
+func sign(parts ...string) string {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign authenticates value for purpose, for the handlers to keep it in a
+// cookie the client cannot alter.
+func Sign(purpose, value string) string {
+	return sign(purpose, value)
+}
+
+// CSRFToken derives the CSRF token of a session from its cookie value, so
+// that it needs no storage and changes with the session.
+func CSRFToken(session string) string {
+	return sign("csrf", session)
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"{{projectName}}/db"
+)
+
+// memStore is an in-memory db.AuthStore holding users and roles. Methods
+// a test does not need are left to the nil embedded interface and panic.
+type memStore struct {
+	db.AuthStore
+	users       map[string]*db.User
+	roles       map[string][]string // role name -> permissions
+	events      []*db.AuditEvent
+	erased      map[string][]string // user id -> emails anonymised
+	memberships []*db.Membership
+	invitations []*db.Invitation
+	magicLinks  []*db.MagicLink
+	otps        []*db.Otp
+	lockouts    map[string]*db.Lockout // scope + " " + subject -> lockout
+}
+
+func newMemStore() *memStore {
+	return &memStore{users: map[string]*db.User{}, roles: map[string][]string{}, erased: map[string][]string{}, lockouts: map[string]*db.Lockout{}}
+}
+
+func (s *memStore) addUser(id, email, role string) *db.User {
+	u := &db.User{ID: id, Email: email, Role: role, Status: db.UserStatusActive}
+	s.users[id] = u
+	return u
+}
+
+func (s *memStore) GetUserByIDIncludingDeleted(_ context.Context, id string) (*db.User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (s *memStore) GetUserByID(ctx context.Context, id string) (*db.User, error) {
+	u, err := s.GetUserByIDIncludingDeleted(ctx, id)
+	if err == nil && u.Status == db.UserStatusDeleted {
+		return nil, sql.ErrNoRows
+	}
+	return u, err
+}
+
+func (s *memStore) GetUserByEmail(_ context.Context, email string) (*db.User, error) {
+	for _, u := range s.users {
+		if u.Email == email && u.Status != db.UserStatusDeleted {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memStore) UpdateUserRole(_ context.Context, id, role string) error {
+	s.users[id].Role = role
+	return nil
+}
+
+func (s *memStore) SetUserStatus(_ context.Context, id, status string) error {
+	if status == db.UserStatusDeleted {
+		if _, err := s.checkOwnerships(id); err != nil {
+			return err
+		}
+	}
+	s.users[id].Status = status
+	return nil
+}
+
+func (s *memStore) SetErasureScheduledAt(_ context.Context, id string, at *time.Time) error {
+	if at != nil {
+		if _, err := s.checkOwnerships(id); err != nil {
+			return err
+		}
+	}
+	s.users[id].ErasureScheduledAt = at
+	return nil
+}
+
+func (s *memStore) UpdatePasswordHash(_ context.Context, id, hash string) error {
+	s.users[id].PasswordHash = hash
+	return nil
+}
+
+func (s *memStore) SetMustChangePassword(_ context.Context, id string, must bool) error {
+	s.users[id].MustChangePassword = must
+	return nil
+}
+
+func (s *memStore) DeleteByUserID(context.Context, string) error { return nil }
+
+func (s *memStore) DeleteOtherSessions(context.Context, string, string) error { return nil }
+
+func (s *memStore) addMembership(userID, orgID, role string) {
+	s.memberships = append(s.memberships, &db.Membership{UserID: userID, OrgID: orgID, Role: role})
+}
+
+// staysOwner reports whether the membership is an owner's that still
+// counts, as keepAnOwner does in the Postgres store.
+func (s *memStore) staysOwner(m *db.Membership) bool {
+	u, ok := s.users[m.UserID]
+	return m.Role == db.OrgRoleOwner && ok && u.Status != db.UserStatusDeleted && u.ErasureScheduledAt == nil
+}
+
+// lastOwner reports whether userID is an owner of orgID with no other
+// owner staying.
+func (s *memStore) lastOwner(orgID, userID string) bool {
+	owner := false
+	for _, m := range s.memberships {
+		switch {
+		case m.OrgID != orgID:
+		case m.UserID == userID:
+			owner = m.Role == db.OrgRoleOwner
+		case s.staysOwner(m):
+			return false
+		}
+	}
+	return owner
+}
+
+// checkOwnerships follows the rule of the Postgres store: userID cannot
+// leave an organization with other members and no other owner.
+func (s *memStore) checkOwnerships(userID string) ([]string, error) {
+	var alone []string
+	for _, m := range s.memberships {
+		if m.UserID != userID || !s.lastOwner(m.OrgID, userID) {
+			continue
+		}
+		if slices.ContainsFunc(s.memberships, func(o *db.Membership) bool { return o.OrgID == m.OrgID && o.UserID != userID }) {
+			return nil, db.ErrLastOwner
+		}
+		alone = append(alone, m.OrgID)
+	}
+	return alone, nil
+}
+
+func (s *memStore) DeleteMembership(ctx context.Context, userID string) error {
+	orgID, _ := db.TenantFromContext(ctx)
+	if s.lastOwner(orgID, userID) {
+		return db.ErrLastOwner
+	}
+	s.memberships = slices.DeleteFunc(s.memberships, func(m *db.Membership) bool {
+		return m.OrgID == orgID && m.UserID == userID
+	})
+	return nil
+}
+
+func (s *memStore) GetRoles(context.Context) ([]*db.Role, error) {
+	var roles []*db.Role
+	for name, perms := range s.roles {
+		roles = append(roles, &db.Role{Name: name, Permissions: perms})
+	}
+	return roles, nil
+}
+
+func (s *memStore) GetRolePermissions(_ context.Context, role string) ([]string, error) {
+	return s.roles[role], nil
+}
+
+func (s *memStore) ListAuditEvents(_ context.Context, f db.AuditFilter) ([]*db.AuditEvent, error) {
+	var events []*db.AuditEvent
+	for _, e := range s.events {
+		if strings.HasPrefix(e.Action, f.Action) && (f.Target == "" || e.Target == f.Target) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *memStore) EraseUser(_ context.Context, u *db.User, emails []string, _ string) error {
+	alone, err := s.checkOwnerships(u.ID)
+	if err != nil {
+		return err
+	}
+	s.invitations = slices.DeleteFunc(s.invitations, func(i *db.Invitation) bool {
+		return slices.Contains(emails, i.Email) || slices.Contains(alone, i.OrgID)
+	})
+	s.memberships = slices.DeleteFunc(s.memberships, func(m *db.Membership) bool {
+		return m.UserID == u.ID || slices.Contains(alone, m.OrgID)
+	})
+	s.erased[u.ID] = emails
+	delete(s.users, u.ID)
+	return nil
+}
+
+func (s *memStore) CreateMagicLink(_ context.Context, l *db.MagicLink) error {
+	l.ID = strconv.Itoa(len(s.magicLinks) + 1)
+	s.magicLinks = append(s.magicLinks, l)
+	return nil
+}
+
+func (s *memStore) GetLatestMagicLink(_ context.Context, userID string) (*db.MagicLink, error) {
+	for i := len(s.magicLinks) - 1; i >= 0; i-- {
+		if s.magicLinks[i].UserID == userID {
+			return s.magicLinks[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memStore) DeleteMagicLink(_ context.Context, id string) error {
+	s.magicLinks = slices.DeleteFunc(s.magicLinks, func(l *db.MagicLink) bool { return l.ID == id })
+	return nil
+}
+
+func (s *memStore) CreateOtp(_ context.Context, otp *db.Otp) error {
+	for _, o := range s.otps {
+		if o.UserId == otp.UserId {
+			o.Used = true
+		}
+	}
+	otp.ID = strconv.Itoa(len(s.otps) + 1)
+	s.otps = append(s.otps, otp)
+	return nil
+}
+
+func (s *memStore) GetActiveOtp(_ context.Context, userID string) (*db.Otp, error) {
+	for _, o := range s.otps {
+		if o.UserId == userID && !o.Used {
+			return o, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memStore) MarkOtpAsUsed(_ context.Context, id string) (bool, error) {
+	for _, o := range s.otps {
+		if o.ID == id && !o.Used {
+			o.Used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memStore) GetLockout(_ context.Context, scope, subject string) (*db.Lockout, error) {
+	l, ok := s.lockouts[scope+" "+subject]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return l, nil
+}
+
+// RecordFailure counts as the Postgres store does, with LockDuration.
+func (s *memStore) RecordFailure(_ context.Context, scope, subject string, p db.LockoutPolicy) (*db.Lockout, error) {
+	now := time.Now()
+	l, ok := s.lockouts[scope+" "+subject]
+	if !ok || l.LastFailureAt.Before(now.Add(-p.Reset)) {
+		l = &db.Lockout{Scope: scope, Subject: subject}
+		s.lockouts[scope+" "+subject] = l
+	}
+	l.Failures++
+	l.LastFailureAt = now
+	l.LockedUntil = nil
+	if d := p.LockDuration(l.Failures); d > 0 {
+		until := now.Add(d)
+		l.LockedUntil = &until
+	}
+	return l, nil
+}
+
+func (s *memStore) ClearLockout(_ context.Context, scope, subject string) error {
+	delete(s.lockouts, scope+" "+subject)
+	return nil
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrInvalidHash       = errors.New("invalid password hash")
+)
+
+// PasswordHasher hashes passwords and verifies them against stored hashes.
+// Verify reports whether the password matches, NeedsRehash whether a stored
+// hash should be replaced by a fresh one produced by Hash.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher produces PHC strings of the form
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+func NewArgon2idHasher(p Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{Params: p}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, salt, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.Memory != h.Params.Memory ||
+		p.Iterations != h.Params.Iterations ||
+		p.Parallelism != h.Params.Parallelism ||
+		p.KeyLength != h.Params.KeyLength ||
+		uint32(len(salt)) != h.Params.SaltLength
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// BcryptHasher only exists to verify hashes created before the switch to
+// argon2id; every bcrypt hash is reported as needing a rehash.
+type BcryptHasher struct{}
+
+func (BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(bytes), err
+}
+
+func (BcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (BcryptHasher) NeedsRehash(string) bool { return true }
+
+// MultiHasher hashes with its primary hasher and verifies with whichever
+// hasher understands the stored format.
+type MultiHasher struct {
+	Primary *Argon2idHasher
+	Legacy  BcryptHasher
+}
+
+func NewPasswordHasher(p Argon2Params) *MultiHasher {
+	return &MultiHasher{Primary: NewArgon2idHasher(p)}
+}
+
+func (m *MultiHasher) Hash(password string) (string, error) {
+	return m.Primary.Hash(password)
+}
+
+func (m *MultiHasher) Verify(encoded, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return m.Primary.Verify(encoded, password)
+	case isBcrypt(encoded):
+		return m.Legacy.Verify(encoded, password)
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+func (m *MultiHasher) NeedsRehash(encoded string) bool {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		return m.Primary.NeedsRehash(encoded)
+	}
+	return true
+}
+
+func isBcrypt(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var passwordHasher PasswordHasher = NewPasswordHasher(DefaultArgon2Params)
+
+// SetPasswordHasher replaces the hasher used by HashPassword and
+// VerifyPassword. It is meant to be called once at startup.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
+
+// VerifyPassword reports whether password matches encoded and whether the
+// stored hash is outdated and should be replaced.
+func VerifyPassword(encoded, password string) (ok bool, rehash bool, err error) {
+	ok, err = passwordHasher.Verify(encoded, password)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, passwordHasher.NeedsRehash(encoded), nil
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast; the format does not depend on the cost.
+var testParams = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+	encoded, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want a PHC string with the parameters", encoded)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse", true},
+		{"correct horse ", false},
+		{"Correct horse", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if ok, err := h.Verify(encoded, tt.password); ok != tt.want || err != nil {
+			t.Errorf("Verify(%q) = %v, %v, want %v", tt.password, ok, err, tt.want)
+		}
+	}
+
+	// The parameters come from the hash, not from the hasher.
+	other := NewArgon2idHasher(DefaultArgon2Params)
+	if ok, err := other.Verify(encoded, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify() with other parameters = %v, %v, want true", ok, err)
+	}
+
+	again, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encoded {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+}
+
+func TestArgon2idInvalidHashes(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+	encoded, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encoded, "$")
+
+	tests := map[string]string{
+		"empty":          "",
+		"argon2i":        strings.Replace(encoded, "$argon2id$", "$argon2i$", 1),
+		"other version":  strings.Replace(encoded, "$v=19$", "$v=16$", 1),
+		"bad parameters": strings.Replace(encoded, "m=64,t=1,p=1", "m=64;t=1", 1),
+		"bad salt":       strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$"),
+		"bad key":        strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], "!!"}, "$"),
+		"missing part":   strings.Join(parts[:5], "$"),
+	}
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			if ok, err := h.Verify(encoded, "secret"); ok || !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("Verify() = %v, %v, want %v", ok, err, ErrInvalidHash)
+			}
+			if !h.NeedsRehash(encoded) {
+				t.Error("NeedsRehash() = false for an invalid hash")
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *Argon2Params)
+		want   bool
+	}{
+		{"same parameters", func(p *Argon2Params) {}, false},
+		{"memory", func(p *Argon2Params) { p.Memory *= 2 }, true},
+		{"iterations", func(p *Argon2Params) { p.Iterations++ }, true},
+		{"parallelism", func(p *Argon2Params) { p.Parallelism++ }, true},
+		{"salt length", func(p *Argon2Params) { p.SaltLength = 32 }, true},
+		{"key length", func(p *Argon2Params) { p.KeyLength = 64 }, true},
+	}
+	encoded, err := NewArgon2idHasher(testParams).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testParams
+			tt.change(&p)
+			if got := NewArgon2idHasher(p).NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiHasherBcryptFallback(t *testing.T) {
+	m := NewPasswordHasher(testParams)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := m.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		encoded     string
+		password    string
+		want        bool
+		wantErr     error
+		needsRehash bool
+	}{
+		{"bcrypt", string(legacy), "secret", true, nil, true},
+		{"bcrypt wrong password", string(legacy), "other", false, nil, true},
+		{"bcrypt 2y", strings.Replace(string(legacy), "$2a$", "$2y$", 1), "secret", true, nil, true},
+		{"argon2id", current, "secret", true, nil, false},
+		{"argon2id wrong password", current, "other", false, nil, false},
+		{"plain text", "secret", "secret", false, ErrUnknownHashFormat, true},
+		{"md5", "$1$salt$hash", "secret", false, ErrUnknownHashFormat, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := m.Verify(tt.encoded, tt.password)
+			if ok != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, err, tt.want, tt.wantErr)
+			}
+			if got := m.NeedsRehash(tt.encoded); got != tt.needsRehash {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordReportsRehash(t *testing.T) {
+	defer SetPasswordHasher(passwordHasher)
+	SetPasswordHasher(NewPasswordHasher(testParams))
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash, err := VerifyPassword(string(legacy), "secret"); !ok || !rehash || err != nil {
+		t.Errorf("VerifyPassword(bcrypt) = %v, %v, %v, want a match to rehash", ok, rehash, err)
+	}
+	if ok, rehash, err := VerifyPassword(string(legacy), "other"); ok || rehash || err != nil {
+		t.Errorf("VerifyPassword(bcrypt, wrong) = %v, %v, %v, want no match and no rehash", ok, rehash, err)
+	}
+
+	current, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash, err := VerifyPassword(current, "secret"); !ok || rehash || err != nil {
+		t.Errorf("VerifyPassword(argon2id) = %v, %v, %v, want a match to keep", ok, rehash, err)
+	}
+}
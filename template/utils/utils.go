@@ -1,10 +1,5 @@
 package utils
 
-import (
-	"golang.org/x/crypto/bcrypt"
-)
-
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return passwordHasher.Hash(password)
 }
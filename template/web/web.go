@@ -5,3 +5,8 @@ import "embed"
 //go:embed static/*
 //go:embed template/*
 var WebFs embed.FS
+
+// OpenAPI is the API description written by go generate, see main.go.
+//
+//go:embed openapi.json
+var OpenAPI []byte